@@ -1,7 +1,6 @@
 package mip
 
 import (
-	"bufio"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -9,10 +8,11 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/dvob/mip/ftp"
 	"github.com/spf13/viper"
-	"golang.org/x/text/encoding/charmap"
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
@@ -20,13 +20,14 @@ type AlltronImport struct {
 	name        string
 	cfg         *viper.Viper
 	summary     *ImportSummary
-	output      io.Writer
+	output      RecordWriter
 	prices      map[string]XmlArticlePrice
 	bar         *pb.ProgressBar
+	filter      *Filter
 	initialized bool
 }
 
-func NewAlltronImport(cfg *viper.Viper, output io.Writer) *AlltronImport {
+func NewAlltronImport(cfg *viper.Viper, output RecordWriter) *AlltronImport {
 	a := &AlltronImport{
 		name:    "Alltron",
 		cfg:     cfg,
@@ -46,20 +47,144 @@ func (i *AlltronImport) Name() string {
 }
 
 func (i *AlltronImport) Init() error {
+	filter, err := NewFilter(i.cfg.Sub("filter"))
+	if err != nil {
+		return fmt.Errorf("filter: %w", err)
+	}
+	// the lists of ignored values from before the filter
+	if i.cfg.IsSet("ignored") {
+		log.Println("ignored.MAFT and ignored.CAT1 are deprecated, use filter.exclude")
+	}
+	filter.ExcludeValues(AttrManufacturer, i.cfg.GetStringSlice("ignored.MAFT"), "ignored MAFT")
+	filter.ExcludeValues(AttrSupplierCategory, i.cfg.GetStringSlice("ignored.CAT1"), "ignored CAT1")
+	i.filter = filter
 	i.initialized = true
 	return nil
 }
 
+// XmlArticlePrice are the prices of an article. There is a price element
+// for every price tier.
 type XmlArticlePrice struct {
-	Id            string  `xml:"LITM"`
-	PurchasePrice float64 `xml:"price>EXPR"`
+	Id     string      `xml:"LITM"`
+	Prices []XmlFields `xml:"price"`
 }
 
 type XmlArticle struct {
-	Id          string `xml:"LITM"`
-	Description string `xml:"part_description>DESC"`
-	Maft        string `xml:"additional_information>MAFT"`
-	Cat1        string `xml:"part_catagory>CAT1"`
+	Id          string    `xml:"LITM"`
+	Description string    `xml:"part_description>DESC"`
+	Info        XmlFields `xml:"additional_information"`
+	Cat1        string    `xml:"part_catagory>CAT1"`
+}
+
+// XmlFields are the child elements of an element with text content.
+type XmlFields struct {
+	Fields []XmlField `xml:",any"`
+}
+
+type XmlField struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// Get returns the content of the element name or an empty string if there is
+// no such element.
+func (f XmlFields) Get(name string) string {
+	for _, field := range f.Fields {
+		if field.XMLName.Local == name {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// alltronAttributes are the elements in additional_information which contain
+// the attributes of an article. they can be changed and extended with the
+// setting attributes (e.g. ean and mpn, which have no default).
+var alltronAttributes = map[string]string{
+	AttrManufacturer: "MAFT",
+}
+
+func (i *AlltronImport) attributes() map[string]string {
+	attributes := map[string]string{}
+	for name, element := range alltronAttributes {
+		attributes[name] = element
+	}
+	for name, element := range i.cfg.GetStringMapString("attributes") {
+		attributes[name] = element
+	}
+	return attributes
+}
+
+// alltronPriceElements are the elements in price which contain the price,
+// the minimal quantity of the price tier and the package size. they can be
+// changed with the setting price_elements. quantity and package_size have no
+// default, without quantity only the first price is used.
+var alltronPriceElements = map[string]string{
+	"price": "EXPR",
+}
+
+func (i *AlltronImport) priceElements() map[string]string {
+	elements := map[string]string{}
+	for name, element := range alltronPriceElements {
+		elements[name] = element
+	}
+	for name, element := range i.cfg.GetStringMapString("price_elements") {
+		elements[name] = element
+	}
+	return elements
+}
+
+// errNoPrice is returned by setPrices if the article has no price.
+var errNoPrice = fmt.Errorf("no price")
+
+// setPrices sets the prices of r from the price elements of p. The price
+// with the lowest quantity is the price of r, the others are its price
+// tiers. Price elements without quantity are for a single unit. An article
+// without price or without price for a single unit is invalid.
+func (i *AlltronImport) setPrices(r *Record, p *XmlArticlePrice, elements map[string]string) error {
+	var tiers []PriceTier
+	for _, price := range p.Prices {
+		value := strings.TrimSpace(price.Get(elements["price"]))
+		if value == "" {
+			continue
+		}
+		amount, err := ParseMoney(value)
+		if err != nil {
+			return fmt.Errorf("invalid price '%s'", value)
+		}
+		quantity := 1
+		if value := strings.TrimSpace(price.Get(elements["quantity"])); value != "" {
+			quantity, err = strconv.Atoi(value)
+			if err != nil || quantity < 1 {
+				return fmt.Errorf("invalid quantity '%s'", value)
+			}
+		}
+		tiers = append(tiers, PriceTier{
+			Quantity:      quantity,
+			PurchasePrice: amount,
+			SellingPrice:  amount.Mul(r.SellingFactor),
+		})
+		if r.Attr(AttrPackageSize) == "" {
+			r.SetAttr(AttrPackageSize, price.Get(elements[AttrPackageSize]))
+		}
+		// without quantities the price tiers can not be told apart
+		if elements["quantity"] == "" {
+			break
+		}
+	}
+	if len(tiers) == 0 {
+		return errNoPrice
+	}
+	SortPriceTiers(tiers)
+	if tiers[0].Quantity != 1 {
+		return fmt.Errorf("no price for a single unit, the lowest quantity is %d", tiers[0].Quantity)
+	}
+	r.PurchasePrice = tiers[0].PurchasePrice
+	r.SellingPrice = tiers[0].SellingPrice
+	if len(tiers) > 1 {
+		r.PriceTiers = tiers[1:]
+	}
+	return nil
 }
 
 func (i *AlltronImport) getFtpReaders() (ar, pr io.ReadCloser, err error) {
@@ -76,7 +201,7 @@ func (i *AlltronImport) getFtpReaders() (ar, pr io.ReadCloser, err error) {
 			i.cfg.GetString("ftp_password"),
 			i.cfg.GetString("ftp_article_file"))
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, sourceErrorf("failed to open article file: %w", err)
 		}
 
 		priceReader, _, err = ftp.SFTPOpen(
@@ -85,7 +210,7 @@ func (i *AlltronImport) getFtpReaders() (ar, pr io.ReadCloser, err error) {
 			i.cfg.GetString("ftp_password"),
 			i.cfg.GetString("ftp_price_file"))
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, sourceErrorf("failed to open price file: %w", err)
 		}
 	} else {
 		log.Println("use ftp")
@@ -95,7 +220,7 @@ func (i *AlltronImport) getFtpReaders() (ar, pr io.ReadCloser, err error) {
 			i.cfg.GetString("ftp_password"),
 			i.cfg.GetString("ftp_article_file"))
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, sourceErrorf("failed to open article file: %w", err)
 		}
 
 		priceReader, _, err = ftp.Open(
@@ -104,7 +229,7 @@ func (i *AlltronImport) getFtpReaders() (ar, pr io.ReadCloser, err error) {
 			i.cfg.GetString("ftp_password"),
 			i.cfg.GetString("ftp_price_file"))
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, sourceErrorf("failed to open price file: %w", err)
 		}
 	}
 
@@ -118,17 +243,17 @@ func (i *AlltronImport) getFileReaders() (ar, pr io.ReadCloser, err error) {
 	log.Println("use local file")
 	articleFile, err := os.Open(i.cfg.GetString("article_file"))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, sourceErrorf("failed to open article file: %w", err)
 	}
 
 	priceFile, err := os.Open(i.cfg.GetString("price_file"))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, sourceErrorf("failed to open price file: %w", err)
 	}
 	if i.bar != nil {
 		fi, err := articleFile.Stat()
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, sourceErrorf("failed to open article file: %w", err)
 		}
 		i.bar.Total = fi.Size()
 	}
@@ -151,10 +276,15 @@ func (i *AlltronImport) Run() (*ImportSummary, error) {
 		}
 	}
 
+	var articleName, priceName string
 	if i.cfg.GetBool("use_ftp") {
 		articleReader, priceReader, err = i.getFtpReaders()
+		articleName = i.cfg.GetString("ftp_address") + ":" + i.cfg.GetString("ftp_article_file")
+		priceName = i.cfg.GetString("ftp_address") + ":" + i.cfg.GetString("ftp_price_file")
 	} else {
 		articleReader, priceReader, err = i.getFileReaders()
+		articleName = i.cfg.GetString("article_file")
+		priceName = i.cfg.GetString("price_file")
 	}
 	if err != nil {
 		return i.summary, err
@@ -162,6 +292,9 @@ func (i *AlltronImport) Run() (*ImportSummary, error) {
 	defer articleReader.Close()
 	defer priceReader.Close()
 
+	articleReader = ioutil.NopCloser(i.summary.trackSource(articleName, articleReader))
+	priceReader = ioutil.NopCloser(i.summary.trackSource(priceName, priceReader))
+
 	if i.cfg.GetBool("show_progress") {
 		articleReader = i.bar.NewProxyReader(articleReader)
 	}
@@ -172,7 +305,7 @@ func (i *AlltronImport) Run() (*ImportSummary, error) {
 				i.cfg.GetString("ftp_save_dir"),
 				filepath.Base(i.cfg.GetString("ftp_article_file"))))
 		if err != nil {
-			return i.summary, err
+			return i.summary, outputErrorf("failed to save article file: %w", err)
 		}
 		defer articleFtpSave.Close()
 		articleReader = ioutil.NopCloser(io.TeeReader(articleReader, articleFtpSave))
@@ -182,7 +315,7 @@ func (i *AlltronImport) Run() (*ImportSummary, error) {
 				i.cfg.GetString("ftp_save_dir"),
 				filepath.Base(i.cfg.GetString("ftp_price_file"))))
 		if err != nil {
-			return i.summary, err
+			return i.summary, outputErrorf("failed to save price file: %w", err)
 		}
 		defer priceFtpSave.Close()
 		priceReader = ioutil.NopCloser(io.TeeReader(priceReader, priceFtpSave))
@@ -191,7 +324,7 @@ func (i *AlltronImport) Run() (*ImportSummary, error) {
 	if i.cfg.GetBool("show_progress") {
 		i.bar.Start()
 	}
-	_, err = i.process(articleReader, priceReader)
+	err = i.unpackAndProcess(articleReader, priceReader)
 	if i.cfg.GetBool("show_progress") {
 		i.bar.Finish()
 	}
@@ -203,25 +336,57 @@ func (i *AlltronImport) Run() (*ImportSummary, error) {
 
 }
 
-func (i *AlltronImport) process(articleReader, priceReader io.Reader) (*ImportSummary, error) {
-	i.summary.Start()
-
-	outputBufWriter := bufio.NewWriter(i.output)
+// unpackAndProcess unpacks the inputs after the progress bar and the save
+// files are set up, so both of them operate on the compressed bytes.
+func (i *AlltronImport) unpackAndProcess(articleReader, priceReader io.Reader) error {
+	articleContent, err := Unpack(articleReader, i.cfg.GetString("article_member"))
+	if err != nil {
+		return err
+	}
+	priceContent, err := Unpack(priceReader, i.cfg.GetString("price_member"))
+	if err != nil {
+		return err
+	}
+	_, err = i.process(articleContent, priceContent)
+	if err != nil {
+		return err
+	}
 
-	charsetReaderFunc := func(charset string, input io.Reader) (io.Reader, error) {
-		if charset == "ISO-8859-1" || charset == "Windows-1252" {
-			// Windows-1252 is a superset of ISO-8859-1, so should do here
-			return charmap.Windows1252.NewDecoder().Reader(input), nil
+	// the price file is only read as far as needed. read the rest of the
+	// inputs, so the saved files and the source fingerprints are complete.
+	for _, r := range []io.Reader{articleReader, priceReader} {
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			return sourceErrorf("failed to read input: %w", err)
 		}
-		return nil, fmt.Errorf("Unknown charset: %s", charset)
 	}
+	return nil
+}
 
-	articleDecoder := xml.NewDecoder(articleReader)
-	articleDecoder.CharsetReader = charsetReaderFunc
-	priceDecoder := xml.NewDecoder(priceReader)
-	priceDecoder.CharsetReader = charsetReaderFunc
+// inputEncoding returns the encoding configured for the file source (article
+// or price). Without configuration the encoding is detected.
+func (i *AlltronImport) inputEncoding(source string) string {
+	if key := source + "_input_encoding"; i.cfg.IsSet(key) {
+		return i.cfg.GetString(key)
+	}
+	return i.cfg.GetString("input_encoding")
+}
+
+func (i *AlltronImport) process(articleReader, priceReader io.Reader) (*ImportSummary, error) {
+	i.summary.Start()
+	defer i.summary.Stop()
+
+	articleDecoder, err := NewXMLDecoder(articleReader, i.inputEncoding("article"))
+	if err != nil {
+		return nil, err
+	}
+	priceDecoder, err := NewXMLDecoder(priceReader, i.inputEncoding("price"))
+	if err != nil {
+		return nil, err
+	}
 
 	var inElement string
+	attributes := i.attributes()
+	priceElements := i.priceElements()
 
 XML_TOKEN:
 	for {
@@ -230,7 +395,7 @@ XML_TOKEN:
 			if err == io.EOF {
 				break
 			}
-			return nil, err
+			return nil, WrapError(KindParse, err)
 		}
 		switch se := t.(type) {
 		case xml.StartElement:
@@ -239,38 +404,45 @@ XML_TOKEN:
 				var a XmlArticle
 				articleDecoder.DecodeElement(&a, &se)
 				i.summary.Articles++
-				categories := i.cfg.GetStringSlice("ignored.MAFT")
-				for _, categorie := range categories {
-					if a.Maft == categorie {
-						i.summary.Ignored++
-						continue XML_TOKEN
-					}
-				}
-				categories = i.cfg.GetStringSlice("ignored.CAT1")
-				for _, categorie := range categories {
-					if a.Cat1 == categorie {
-						i.summary.Ignored++
-						continue XML_TOKEN
-					}
-				}
-				p, err := i.getPrice(a.Id, priceDecoder)
-				if err != nil {
-					log.Println(err)
-					continue XML_TOKEN
-				}
-
 				r := &Record{
 					Id:             a.Id,
 					IdPrefix:       i.cfg.GetString("id_prefix"),
 					Description:    a.Description,
-					PurchasePrice:  p.PurchasePrice,
 					PurchaseFactor: i.cfg.GetFloat64("purchase_factor"),
 					SellingFactor:  i.cfg.GetFloat64("selling_factor"),
-					SellingPrice:   p.PurchasePrice * i.cfg.GetFloat64("selling_factor"),
 					Category:       "Alltron",
 					CategoryNumber: i.cfg.GetString("category_number"),
 				}
-				_, err = outputBufWriter.WriteString(r.FormatLine())
+				for name, element := range attributes {
+					r.SetAttr(name, a.Info.Get(element))
+				}
+				r.SetAttr(AttrSupplierCategory, a.Cat1)
+				// ignored articles are not looked up in the price file, so
+				// they are not rejected if they have no price
+				if reason, ignored := i.filter.IgnoreUnpriced(r); ignored {
+					i.summary.Ignore(reason)
+					continue XML_TOKEN
+				}
+				p, err := i.getPrice(a.Id, priceDecoder)
+				if err != nil {
+					log.Println(err)
+					i.summary.Reject(&Rejection{Id: a.Id, Reason: "price not found", Detail: a.Description})
+					continue XML_TOKEN
+				}
+				if err := i.setPrices(r, p, priceElements); err == errNoPrice {
+					log.Printf("%s: %s\n", a.Id, err)
+					i.summary.Reject(&Rejection{Id: a.Id, Reason: "price not found", Detail: a.Description})
+					continue XML_TOKEN
+				} else if err != nil {
+					log.Printf("%s: %s\n", a.Id, err)
+					i.summary.Reject(&Rejection{Id: a.Id, Reason: "invalid price", Detail: err.Error()})
+					continue XML_TOKEN
+				}
+				if reason, ignored := i.filter.Ignore(r); ignored {
+					i.summary.Ignore(reason)
+					continue XML_TOKEN
+				}
+				err = i.output.WriteRecord(r)
 				if err != nil {
 					return i.summary, err
 				}
@@ -279,10 +451,6 @@ XML_TOKEN:
 
 	}
 
-	err := outputBufWriter.Flush()
-	if err != nil {
-		return i.summary, err
-	}
 	return i.summary, nil
 }
 
@@ -0,0 +1,110 @@
+package mip
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestAlltronSetPrices(t *testing.T) {
+	tiered := map[string]string{"price": "EXPR", "quantity": "QTY", AttrPackageSize: "PACK"}
+	for _, test := range []struct {
+		name     string
+		xml      string
+		elements map[string]string
+		err      bool
+		price    Money
+		tiers    []PriceTier
+		pack     string
+	}{
+		{
+			name:     "single price",
+			xml:      `<item><LITM>1</LITM><price><EXPR>19.90</EXPR></price></item>`,
+			elements: alltronPriceElements,
+			price:    199000,
+		},
+		{
+			name:     "tiers without quantity element",
+			xml:      `<item><LITM>1</LITM><price><EXPR>19.90</EXPR></price><price><EXPR>17.00</EXPR></price></item>`,
+			elements: alltronPriceElements,
+			price:    199000,
+		},
+		{
+			name:     "tiers",
+			xml:      `<item><LITM>1</LITM><price><EXPR>17.00</EXPR><QTY>10</QTY><PACK>5</PACK></price><price><EXPR>19.90</EXPR><QTY>1</QTY></price><price><EXPR>15</EXPR><QTY>100</QTY></price></item>`,
+			elements: tiered,
+			price:    199000,
+			tiers:    []PriceTier{{10, 170000, 170000}, {100, 150000, 150000}},
+			pack:     "5",
+		},
+		{
+			name:     "price without quantity is a unit price",
+			xml:      `<item><LITM>1</LITM><price><EXPR>17.00</EXPR><QTY>10</QTY></price><price><EXPR>19.90</EXPR></price></item>`,
+			elements: tiered,
+			price:    199000,
+			tiers:    []PriceTier{{10, 170000, 170000}},
+		},
+		{
+			name:     "no unit price",
+			xml:      `<item><LITM>1</LITM><price><EXPR>17.00</EXPR><QTY>10</QTY></price></item>`,
+			elements: tiered,
+			err:      true,
+		},
+		{
+			name:     "empty price",
+			xml:      `<item><LITM>1</LITM><price><EXPR> </EXPR></price></item>`,
+			elements: alltronPriceElements,
+			err:      true,
+		},
+		{
+			name:     "no price element",
+			xml:      `<item><LITM>1</LITM></item>`,
+			elements: alltronPriceElements,
+			err:      true,
+		},
+		{
+			name:     "invalid price",
+			xml:      `<item><LITM>1</LITM><price><EXPR>n/a</EXPR></price></item>`,
+			elements: alltronPriceElements,
+			err:      true,
+		},
+		{
+			name:     "invalid quantity",
+			xml:      `<item><LITM>1</LITM><price><EXPR>1</EXPR><QTY>0</QTY></price></item>`,
+			elements: tiered,
+			err:      true,
+		},
+	} {
+		var p XmlArticlePrice
+		if err := xml.Unmarshal([]byte(test.xml), &p); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		r := &Record{Id: p.Id, SellingFactor: 1}
+		err := (&AlltronImport{}).setPrices(r, &p, test.elements)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if r.PurchasePrice != test.price || r.SellingPrice != test.price {
+			t.Errorf("%s: prices %s and %s, want %s", test.name, r.PurchasePrice, r.SellingPrice, test.price)
+		}
+		if len(r.PriceTiers) != len(test.tiers) {
+			t.Errorf("%s: tiers %v, want %v", test.name, r.PriceTiers, test.tiers)
+		} else {
+			for n := range test.tiers {
+				if r.PriceTiers[n] != test.tiers[n] {
+					t.Errorf("%s: tiers %v, want %v", test.name, r.PriceTiers, test.tiers)
+					break
+				}
+			}
+		}
+		if got := r.Attr(AttrPackageSize); got != test.pack {
+			t.Errorf("%s: package size %q, want %q", test.name, got, test.pack)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package mip
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var articlesBucket = []byte("articles")
+
+// ArticleDB stores the articles of all runs. For each article it keeps when
+// it was seen first and last, when it disappeared and the history of its
+// prices.
+type ArticleDB struct {
+	db *bolt.DB
+}
+
+// Article is an article in the ArticleDB.
+type Article struct {
+	Supplier    string    `json:"supplier"`
+	Key         string    `json:"key"`
+	Description string    `json:"description"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	// Gone is the time of the first run in which the article was missing. It
+	// is zero as long as the article is available.
+	Gone   time.Time     `json:"gone,omitempty"`
+	Prices []*PricePoint `json:"prices"`
+}
+
+// PricePoint are the prices of an article since Time.
+type PricePoint struct {
+	Time          time.Time `json:"time"`
+	PurchasePrice Money     `json:"purchase_price"`
+	SellingPrice  Money     `json:"selling_price"`
+}
+
+// Price returns the current prices of the article.
+func (a *Article) Price() *PricePoint {
+	return a.Prices[len(a.Prices)-1]
+}
+
+// Available returns true if the article was part of the last run.
+func (a *Article) Available() bool {
+	return a.Gone.IsZero()
+}
+
+// ArticleChanges are the differences of a run to the previous run of the same
+// supplier.
+type ArticleChanges struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Contains returns true if the record with key was added or changed.
+func (c *ArticleChanges) Contains(key string) bool {
+	for _, list := range [][]string{c.Added, c.Changed} {
+		i := sort.SearchStrings(list, key)
+		if i < len(list) && list[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
+type changesWriter struct {
+	w       RecordWriter
+	changes *ArticleChanges
+}
+
+func (cw *changesWriter) WriteRecord(r *Record) error {
+	if !cw.changes.Contains(r.Key()) {
+		return nil
+	}
+	return cw.w.WriteRecord(r)
+}
+
+// Filter returns a RecordWriter which writes only the added and changed
+// records to w. Like this an export only contains the differences to the
+// previous run.
+func (c *ArticleChanges) Filter(w RecordWriter) RecordWriter {
+	return &changesWriter{w: w, changes: c}
+}
+
+// OpenArticleDB opens the article database in path. The file is created if
+// it does not exist. Only one process can open the database at the time, so
+// OpenArticleDB waits up to a minute for the database.
+func OpenArticleDB(path string) (*ArticleDB, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Minute})
+	if err != nil {
+		return nil, outputErrorf("failed to open article database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(articlesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, outputErrorf("failed to open article database: %w", err)
+	}
+	return &ArticleDB{db: db}, nil
+}
+
+func (a *ArticleDB) Close() error {
+	return a.db.Close()
+}
+
+func articleKey(supplier, key string) []byte {
+	return []byte(supplier + "/" + key)
+}
+
+// Changes compares records with the articles of supplier in the database.
+func (a *ArticleDB) Changes(supplier string, records []*Record) (*ArticleChanges, error) {
+	changes := &ArticleChanges{}
+	err := a.db.View(func(tx *bolt.Tx) error {
+		seen := make(map[string]bool, len(records))
+		b := tx.Bucket(articlesBucket)
+		for _, r := range records {
+			seen[r.Key()] = true
+			content := b.Get(articleKey(supplier, r.Key()))
+			if content == nil {
+				changes.Added = append(changes.Added, r.Key())
+				continue
+			}
+			article := &Article{}
+			if err := json.Unmarshal(content, article); err != nil {
+				return err
+			}
+			if !article.Available() {
+				changes.Added = append(changes.Added, r.Key())
+			} else if p := article.Price(); p.PurchasePrice != r.PurchasePrice || p.SellingPrice != r.SellingPrice {
+				changes.Changed = append(changes.Changed, r.Key())
+			}
+		}
+		return a.forEach(tx, supplier, func(article *Article) error {
+			if article.Available() && !seen[article.Key] {
+				changes.Removed = append(changes.Removed, article.Key)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, outputErrorf("failed to read article database: %w", err)
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+	return changes, nil
+}
+
+// Record stores the records of a run of supplier at time t. The articles of
+// supplier which are not part of records are marked as gone.
+func (a *ArticleDB) Record(supplier string, t time.Time, records []*Record) error {
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(articlesBucket)
+		seen := make(map[string]bool, len(records))
+		for _, r := range records {
+			seen[r.Key()] = true
+			key := articleKey(supplier, r.Key())
+			article := &Article{
+				Supplier:  supplier,
+				Key:       r.Key(),
+				FirstSeen: t,
+			}
+			if content := b.Get(key); content != nil {
+				if err := json.Unmarshal(content, article); err != nil {
+					return err
+				}
+			}
+			article.Description = r.Description
+			article.LastSeen = t
+			article.Gone = time.Time{}
+			if len(article.Prices) == 0 || article.Price().PurchasePrice != r.PurchasePrice || article.Price().SellingPrice != r.SellingPrice {
+				article.Prices = append(article.Prices, &PricePoint{
+					Time:          t,
+					PurchasePrice: r.PurchasePrice,
+					SellingPrice:  r.SellingPrice,
+				})
+			}
+			if err := putArticle(b, article); err != nil {
+				return err
+			}
+		}
+		return a.forEach(tx, supplier, func(article *Article) error {
+			if !article.Available() || seen[article.Key] {
+				return nil
+			}
+			article.Gone = t
+			return putArticle(b, article)
+		})
+	})
+	if err != nil {
+		return outputErrorf("failed to update article database: %w", err)
+	}
+	return nil
+}
+
+func putArticle(b *bolt.Bucket, article *Article) error {
+	content, err := json.Marshal(article)
+	if err != nil {
+		return err
+	}
+	return b.Put(articleKey(article.Supplier, article.Key), content)
+}
+
+// forEach calls fn for each article of supplier. If supplier is empty fn is
+// called for all articles.
+func (a *ArticleDB) forEach(tx *bolt.Tx, supplier string, fn func(*Article) error) error {
+	var articles []*Article
+	c := tx.Bucket(articlesBucket).Cursor()
+	prefix := []byte(supplier + "/")
+	if supplier == "" {
+		prefix = nil
+	}
+	for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+		article := &Article{}
+		if err := json.Unmarshal(v, article); err != nil {
+			return err
+		}
+		articles = append(articles, article)
+	}
+	// fn may modify the bucket which is not allowed during the iteration
+	for _, article := range articles {
+		if err := fn(article); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find returns the articles with key of all suppliers.
+func (a *ArticleDB) Find(key string) ([]*Article, error) {
+	var found []*Article
+	err := a.db.View(func(tx *bolt.Tx) error {
+		return a.forEach(tx, "", func(article *Article) error {
+			if strings.EqualFold(article.Key, key) {
+				found = append(found, article)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, outputErrorf("failed to read article database: %w", err)
+	}
+	return found, nil
+}
+
+// ChangedSince returns the articles which appeared, disappeared or changed
+// their price since t.
+func (a *ArticleDB) ChangedSince(t time.Time) ([]*Article, error) {
+	var changed []*Article
+	err := a.db.View(func(tx *bolt.Tx) error {
+		return a.forEach(tx, "", func(article *Article) error {
+			if !article.FirstSeen.Before(t) || !article.Gone.Before(t) || !article.Price().Time.Before(t) {
+				changed = append(changed, article)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, outputErrorf("failed to read article database: %w", err)
+	}
+	return changed, nil
+}
@@ -0,0 +1,134 @@
+package mip
+
+import (
+	"strings"
+)
+
+// names of the well-known attributes of a record. importers may set other
+// attributes too.
+const (
+	AttrEAN          = "ean"
+	AttrManufacturer = "manufacturer"
+	// manufacturer part number
+	AttrMPN      = "mpn"
+	AttrStock    = "stock"
+	AttrUnit     = "unit"
+	AttrWeight   = "weight"
+	AttrVATCode  = "vat_code"
+	AttrImageURL = "image_url"
+)
+
+// Attributes are the optional properties of an article by name. An attribute
+// which the supplier does not provide is not set.
+type Attributes map[string]string
+
+// Attr returns the attribute name of the record or an empty string if it is
+// not set.
+func (r *Record) Attr(name string) string {
+	return r.Attributes[name]
+}
+
+// SetAttr sets the attribute name of the record. Empty values are ignored.
+func (r *Record) SetAttr(name, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	if r.Attributes == nil {
+		r.Attributes = Attributes{}
+	}
+	r.Attributes[name] = value
+}
+
+// ExportColumn is a column of the export.
+type ExportColumn struct {
+	Name  string
+	Title string
+	// value returns the content of the column formatted with f
+	value func(r *Record, f *CSVFormat) string
+}
+
+// DefaultColumns are the columns of the export if no output_columns are
+// configured.
+var DefaultColumns = []ExportColumn{
+	{"id", "Id", func(r *Record, f *CSVFormat) string { return r.Key() }},
+	{"description", "Beschreibung", func(r *Record, f *CSVFormat) string { return f.text(r.Description) }},
+	{"category", "Kategorie", func(r *Record, f *CSVFormat) string { return r.Category }},
+	{"purchase_price", "Einkaufspreis", func(r *Record, f *CSVFormat) string { return f.price(r.PurchasePrice) }},
+	{"purchase_factor", "Einkaufsfaktor", func(r *Record, f *CSVFormat) string { return f.factor(r.PurchaseFactor) }},
+	{"selling_price", "Verkaufspreis", func(r *Record, f *CSVFormat) string { return f.price(r.SellingPrice) }},
+	{"selling_factor", "Verkaufsfaktor", func(r *Record, f *CSVFormat) string { return f.factor(r.SellingFactor) }},
+	{"category_number", "Kategorie-Nummer", func(r *Record, f *CSVFormat) string { return r.CategoryNumber }},
+}
+
+// attributeTitles are the titles of the columns of the well-known attributes.
+// other attributes use their name as title.
+var attributeTitles = map[string]string{
+	AttrEAN:          "EAN",
+	AttrManufacturer: "Hersteller",
+	AttrMPN:          "Hersteller-Nummer",
+	AttrStock:        "Lagerbestand",
+	AttrUnit:         "Einheit",
+	AttrWeight:       "Gewicht",
+	AttrVATCode:      "MWST-Code",
+	AttrImageURL:     "Bild-URL",
+	AttrPackageSize:  "Verpackungseinheit",
+}
+
+// attributeColumn returns the column of the attribute name.
+func attributeColumn(name string) ExportColumn {
+	title, ok := attributeTitles[name]
+	if !ok {
+		title = name
+	}
+	return ExportColumn{name, title, func(r *Record, f *CSVFormat) string { return f.text(r.Attr(name)) }}
+}
+
+// ExportColumns returns the columns with the given names. The names are the
+// ones of DefaultColumns, of price tier columns (see tierColumns) or of
+// attributes. Without names DefaultColumns are returned.
+func ExportColumns(names []string) ([]ExportColumn, error) {
+	if len(names) == 0 {
+		return DefaultColumns, nil
+	}
+	columns := make([]ExportColumn, 0, len(names))
+NAMES:
+	for _, name := range names {
+		for _, c := range DefaultColumns {
+			if c.Name == name {
+				columns = append(columns, c)
+				continue NAMES
+			}
+		}
+		if tiers := tierColumns(name); tiers != nil {
+			columns = append(columns, tiers...)
+			continue
+		}
+		if strings.TrimSpace(name) == "" {
+			return nil, configErrorf("empty column name")
+		}
+		columns = append(columns, attributeColumn(name))
+	}
+	return columns, nil
+}
+
+// isDefaultColumn returns true if title is the title of one of the
+// DefaultColumns.
+func isDefaultColumn(title string) bool {
+	for _, c := range DefaultColumns {
+		if c.Title == title {
+			return true
+		}
+	}
+	return false
+}
+
+// attributeByTitle returns the name of the attribute with the column title.
+func attributeByTitle(title string) string {
+	for name, t := range attributeTitles {
+		if t == title {
+			return name
+		}
+	}
+	return title
+}
@@ -0,0 +1,126 @@
+package mip
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// AttrSupplierCategory is the attribute with the category of the article in
+// the catalog of the supplier (e.g. CAT1 of Alltron).
+const AttrSupplierCategory = "supplier_category"
+
+type categoryRule struct {
+	match func(category string) bool
+	// rule describes the rule in errors and reports
+	rule     string
+	category string
+	number   string
+}
+
+// CategoryMapper is a RecordWriter which sets the category and the category
+// number of the records by the supplier category before it writes them to the
+// next writer. The rules are read from cfg:
+//
+//	rules     list of rules, the first matching rule is applied. a rule
+//	          matches the supplier category with one of:
+//	            exact    the same category (case insensitive)
+//	            prefix   a category starting with prefix (case insensitive)
+//	            regex    a category matching the regular expression
+//	          and sets category and/or number
+//	default   category and/or number of the records without matching rule
+//
+// Records without matching rule and default keep the category of the importer.
+// They are counted as unmapped.
+type CategoryMapper struct {
+	rules []*categoryRule
+	def   *categoryRule
+	w     RecordWriter
+	// Unmapped contains the number of records by supplier category which no
+	// rule matched
+	Unmapped map[string]int
+}
+
+func NewCategoryMapper(cfg *viper.Viper, w RecordWriter) (*CategoryMapper, error) {
+	m := &CategoryMapper{
+		w:        w,
+		Unmapped: map[string]int{},
+	}
+	for n, entry := range cast.ToSlice(cfg.Get("rules")) {
+		rule, err := newCategoryRule(cast.ToStringMapString(entry))
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", n, err)
+		}
+		m.rules = append(m.rules, rule)
+	}
+	if cfg.IsSet("default") {
+		m.def = &categoryRule{
+			rule:     "default",
+			category: cfg.GetString("default.category"),
+			number:   cfg.GetString("default.number"),
+		}
+	}
+	return m, nil
+}
+
+func newCategoryRule(entry map[string]string) (*categoryRule, error) {
+	rule := &categoryRule{
+		category: entry["category"],
+		number:   entry["number"],
+	}
+	var err error
+	rule.match, rule.rule, err = newCategoryMatch(entry)
+	if err != nil {
+		return nil, err
+	}
+	if rule.category == "" && rule.number == "" {
+		return nil, configErrorf("rule '%s' sets neither category nor number", rule.rule)
+	}
+	return rule, nil
+}
+
+// newCategoryMatch returns a function which matches a supplier category with
+// the exact, prefix or regex of the rule entry and a description of the rule.
+func newCategoryMatch(entry map[string]string) (func(category string) bool, string, error) {
+	if exact, ok := entry["exact"]; ok {
+		return func(category string) bool { return strings.EqualFold(category, exact) }, "exact " + exact, nil
+	}
+	if prefix, ok := entry["prefix"]; ok {
+		lower := strings.ToLower(prefix)
+		return func(category string) bool { return strings.HasPrefix(strings.ToLower(category), lower) }, "prefix " + prefix, nil
+	}
+	if pattern, ok := entry["regex"]; ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, "", configErrorf("invalid regex: %w", err)
+		}
+		return re.MatchString, "regex " + pattern, nil
+	}
+	return nil, "", configErrorf("rule needs exact, prefix or regex")
+}
+
+func (m *CategoryMapper) WriteRecord(r *Record) error {
+	category := r.Attr(AttrSupplierCategory)
+	rule := m.def
+	for _, candidate := range m.rules {
+		if candidate.match(category) {
+			rule = candidate
+			break
+		}
+	}
+	if rule == nil || rule == m.def {
+		m.Unmapped[category]++
+	}
+	if rule != nil {
+		if rule.category != "" {
+			r.Category = rule.category
+		}
+		if rule.number != "" {
+			r.CategoryNumber = rule.number
+		}
+	}
+	return m.w.WriteRecord(r)
+}
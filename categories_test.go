@@ -0,0 +1,115 @@
+package mip
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCategoryMapper(t *testing.T) {
+	cfg := viper.New()
+	cfg.Set("rules", []interface{}{
+		map[string]interface{}{"exact": "Kabel", "category": "Kabel", "number": "10"},
+		map[string]interface{}{"prefix": "Eingabegeräte/", "category": "Eingabegeräte"},
+		map[string]interface{}{"regex": `^(Drucker|Scanner)\b`, "number": "30"},
+		// never applied, the prefix rule matches first
+		map[string]interface{}{"exact": "Eingabegeräte/Mäuse", "category": "Mäuse"},
+		// regex are case sensitive
+		map[string]interface{}{"regex": "^monitor", "category": "Monitore"},
+	})
+	cfg.Set("default", map[string]interface{}{"category": "Diverses", "number": "99"})
+	out := &RecordBuffer{}
+	m, err := NewCategoryMapper(cfg, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		supplier string
+		category string
+		number   string
+	}{
+		{"Kabel", "Kabel", "10"},
+		{"KABEL", "Kabel", "10"},
+		{"Kabel/USB", "Diverses", "99"},
+		{"Eingabegeräte/Tastaturen", "Eingabegeräte", "1"},
+		{"EINGABEGERÄTE/Mäuse", "Eingabegeräte", "1"},
+		{"Eingabegeräte/Mäuse", "Eingabegeräte", "1"},
+		{"Drucker Laser", "importer", "30"},
+		{"Scanner", "importer", "30"},
+		{"Druckerpapier", "Diverses", "99"},
+		{"Monitore", "Diverses", "99"},
+		{"", "Diverses", "99"},
+		{"Monitore", "Diverses", "99"},
+	} {
+		r := &Record{Category: "importer", CategoryNumber: "1"}
+		r.SetAttr(AttrSupplierCategory, test.supplier)
+		if err := m.WriteRecord(r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Category != test.category || r.CategoryNumber != test.number {
+			t.Errorf("%q: category %q, %q, want %q, %q", test.supplier, r.Category, r.CategoryNumber, test.category, test.number)
+		}
+	}
+	if len(out.Records) != 12 {
+		t.Errorf("%d records written, want 12", len(out.Records))
+	}
+	want := map[string]int{"Kabel/USB": 1, "Druckerpapier": 1, "Monitore": 2, "": 1}
+	if len(m.Unmapped) != len(want) {
+		t.Errorf("Unmapped = %v, want %v", m.Unmapped, want)
+	}
+	for category, n := range want {
+		if m.Unmapped[category] != n {
+			t.Errorf("Unmapped[%q] = %d, want %d", category, m.Unmapped[category], n)
+		}
+	}
+}
+
+func TestCategoryMapperWithoutDefault(t *testing.T) {
+	cfg := viper.New()
+	cfg.Set("rules", []interface{}{
+		map[string]interface{}{"prefix": "kabel", "number": "10"},
+	})
+	m, err := NewCategoryMapper(cfg, &RecordBuffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		supplier string
+		category string
+		number   string
+	}{
+		// the rule sets the number only
+		{"Kabel/USB", "importer", "10"},
+		// the records without rule keep the category of the importer
+		{"Monitore", "importer", "1"},
+	} {
+		r := &Record{Category: "importer", CategoryNumber: "1"}
+		r.SetAttr(AttrSupplierCategory, test.supplier)
+		if err := m.WriteRecord(r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Category != test.category || r.CategoryNumber != test.number {
+			t.Errorf("%q: category %q, %q, want %q, %q", test.supplier, r.Category, r.CategoryNumber, test.category, test.number)
+		}
+	}
+	if len(m.Unmapped) != 1 || m.Unmapped["Monitore"] != 1 {
+		t.Errorf("Unmapped = %v, want map[Monitore:1]", m.Unmapped)
+	}
+}
+
+func TestCategoryMapperErrors(t *testing.T) {
+	for _, rule := range []map[string]interface{}{
+		{"category": "Kabel"},
+		{"exact": "Kabel"},
+		{"regex": "(", "category": "Kabel"},
+	} {
+		cfg := viper.New()
+		cfg.Set("rules", []interface{}{rule})
+		if _, err := NewCategoryMapper(cfg, &RecordBuffer{}); err == nil {
+			t.Errorf("rule %v: no error", rule)
+		} else if KindOf(err) != KindConfig {
+			t.Errorf("rule %v: %v, want a config error", rule, err)
+		}
+	}
+}
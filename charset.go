@@ -0,0 +1,91 @@
+package mip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// LookupEncoding returns the encoding with the name. The names of the WHATWG
+// encoding standard (used by browsers), the IANA names and the names of
+// Encodings are known. Like in browsers ISO-8859-1 is decoded as its superset
+// Windows-1252.
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	name = strings.TrimSpace(name)
+	if e, err := htmlindex.Get(name); err == nil {
+		return e, nil
+	}
+	if e, err := ianaindex.IANA.Encoding(name); err == nil && e != nil {
+		return e, nil
+	}
+	if e, ok := Encodings[strings.ToLower(name)]; ok {
+		return e, nil
+	}
+	return nil, parseErrorf("unknown charset: %s", name)
+}
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// DecodeInput returns a reader which decodes the text input r to UTF-8. A
+// byte order mark (UTF-8 or UTF-16) determines the encoding. Without byte
+// order mark the input is decoded with enc. If enc is empty too, the input is
+// returned unchanged and decoded is false.
+func DecodeInput(r io.Reader, enc string) (input io.Reader, decoded bool, err error) {
+	br := bufio.NewReader(r)
+	start, _ := br.Peek(3)
+	switch {
+	case bytes.HasPrefix(start, bomUTF8):
+		br.Discard(len(bomUTF8))
+		return br, true, nil
+	case bytes.HasPrefix(start, bomUTF16LE), bytes.HasPrefix(start, bomUTF16BE):
+		// ExpectBOM uses the byte order of the BOM
+		return transform.NewReader(br, unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder()), true, nil
+	}
+	if enc == "" {
+		return br, false, nil
+	}
+	e, err := LookupEncoding(enc)
+	if err != nil {
+		return nil, false, configErrorf("invalid input_encoding: %w", err)
+	}
+	return e.NewDecoder().Reader(br), true, nil
+}
+
+// NewXMLDecoder returns a decoder for the XML input r. Unless enc is set or
+// the input starts with a byte order mark, the input is decoded according to
+// the encoding declaration of the document. enc overrides a wrong
+// declaration.
+func NewXMLDecoder(r io.Reader, enc string) (*xml.Decoder, error) {
+	input, decoded, err := DecodeInput(r, enc)
+	if err != nil {
+		return nil, err
+	}
+	d := xml.NewDecoder(input)
+	if decoded {
+		// the declaration is ignored, the input is already UTF-8
+		d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+		return d, nil
+	}
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		e, err := LookupEncoding(charset)
+		if err != nil {
+			return nil, err
+		}
+		return e.NewDecoder().Reader(input), nil
+	}
+	return d, nil
+}
@@ -0,0 +1,127 @@
+package mip
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestLookupEncoding(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"utf-8", "Größe", "Größe"},
+		{"UTF-8", "Größe", "Größe"},
+		{"latin1", "Gr\xf6\xdfe", "Größe"},
+		{" ISO-8859-1 ", "Gr\xf6\xdfe", "Größe"},
+		// ISO-8859-1 is decoded as Windows-1252
+		{"iso-8859-1", "5\x80", "5€"},
+		{"iso-8859-15", "5\xa4", "5€"},
+		{"cp850", "Gr\x94\xe1e", "Größe"},
+		{"utf-16le", "G\x00r\x00\xf6\x00\xdf\x00e\x00", "Größe"},
+	} {
+		e, err := LookupEncoding(test.name)
+		if err != nil {
+			t.Errorf("LookupEncoding(%q): %s", test.name, err)
+			continue
+		}
+		got, err := e.NewDecoder().String(test.in)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: decoded %q, want %q", test.name, got, test.want)
+		}
+	}
+	for _, name := range []string{"", "klingonisch", "utf-9"} {
+		if _, err := LookupEncoding(name); err == nil {
+			t.Errorf("LookupEncoding(%q) returned no error", name)
+		}
+	}
+}
+
+func TestDecodeInput(t *testing.T) {
+	utf16, err := unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewEncoder().String("Größe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		input   string
+		enc     string
+		want    string
+		decoded bool
+	}{
+		{"utf-8 bom", "\xef\xbb\xbfGröße", "", "Größe", true},
+		// the byte order mark wins over the encoding
+		{"utf-8 bom with encoding", "\xef\xbb\xbfGröße", "iso-8859-1", "Größe", true},
+		{"utf-16 be bom", utf16, "", "Größe", true},
+		{"utf-16 le bom", "\xff\xfeG\x00r\x00\xf6\x00\xdf\x00e\x00", "", "Größe", true},
+		{"encoding", "Gr\xf6\xdfe", "iso-8859-1", "Größe", true},
+		{"unchanged", "Gr\xf6\xdfe", "", "Gr\xf6\xdfe", false},
+		{"empty", "", "", "", false},
+	} {
+		r, decoded, err := DecodeInput(bytes.NewReader([]byte(test.input)), test.enc)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if string(got) != test.want || decoded != test.decoded {
+			t.Errorf("%s: %q, %t, want %q, %t", test.name, got, decoded, test.want, test.decoded)
+		}
+	}
+
+	_, _, err = DecodeInput(bytes.NewReader([]byte("Größe")), "klingonisch")
+	if err == nil {
+		t.Error("unknown encoding: no error")
+	} else if KindOf(err) != KindConfig {
+		t.Errorf("unknown encoding: %v, want a config error", err)
+	}
+}
+
+func TestNewXMLDecoder(t *testing.T) {
+	utf16, err := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String(`<?xml version="1.0" encoding="UTF-16"?><a>Größe</a>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		input string
+		enc   string
+		err   bool
+	}{
+		{"utf-8", `<?xml version="1.0" encoding="UTF-8"?><a>Größe</a>`, "", false},
+		{"utf-8 without declaration", `<a>Größe</a>`, "", false},
+		{"iso-8859-1 declaration", "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a>Gr\xf6\xdfe</a>", "", false},
+		{"windows-1252 declaration", "<?xml version=\"1.0\" encoding=\"windows-1252\"?><a>Gr\xf6\xdfe</a>", "", false},
+		{"utf-8 bom with wrong declaration", "\xef\xbb\xbf<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a>Größe</a>", "", false},
+		{"utf-16 bom", utf16, "", false},
+		// input_encoding overrides the wrong declaration
+		{"wrong declaration", "<?xml version=\"1.0\" encoding=\"UTF-8\"?><a>Gr\xf6\xdfe</a>", "iso-8859-1", false},
+		{"iso-8859-1 without declaration", "<a>Gr\xf6\xdfe</a>", "iso-8859-1", false},
+		{"iso-8859-1 without encoding", "<a>Gr\xf6\xdfe</a>", "", true},
+		{"unknown charset", "<?xml version=\"1.0\" encoding=\"klingonisch\"?><a>Größe</a>", "", true},
+	} {
+		d, err := NewXMLDecoder(bytes.NewReader([]byte(test.input)), test.enc)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		var a string
+		err = d.Decode(&a)
+		if test.err != (err != nil) {
+			t.Errorf("%s: Decode returned %v", test.name, err)
+		} else if err == nil && a != "Größe" {
+			t.Errorf("%s: decoded %q, want %q", test.name, a, "Größe")
+		}
+	}
+
+	if _, err := NewXMLDecoder(bytes.NewReader(nil), "klingonisch"); err == nil {
+		t.Error("unknown input_encoding: no error")
+	}
+}
@@ -6,58 +6,169 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"log"
-	"os"
+	"strings"
 )
 
 var allCmd = &cobra.Command{
 	Use:   "all",
 	Short: "perform all imports (alltron, suprag, mitel)",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		file, err := os.Create(viper.GetString("output_file"))
+		continueOnError := viper.GetBool("continue_on_error")
+
+		dests, err := destinations("")
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "failed to open output file: ", err)
-			os.Exit(1)
+			return err
 		}
-		defer file.Close()
-		export, err := mip.NewExport(file, viper.GetString("output_encoding"))
+		export, err := openExport(viper.GetString("output_file"), dests)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "failed to initialize export: ", err)
-			os.Exit(1)
+			return err
 		}
+		defer export.Discard()
 
-		imports := []mip.Importer{
-			mip.NewAlltronImport(viper.Sub("alltron"), export),
-			mip.NewMitelImport(viper.Sub("mitel"), export),
-			mip.NewSupragImport(viper.Sub("suprag"), export)}
+		// records of an importer are only written to the export if the
+		// importer succeeds. like this a failed importer does not leave
+		// partial data in the export.
+		var (
+			jobs      []*importJob
+			failed    []string
+			lastErr   error
+			succeeded int
+		)
+		for _, name := range mip.ImporterNames() {
+			job, err := newImportJob(name, nil)
+			if err == nil {
+				err = job.acquire()
+			}
+			if err != nil {
+				report.Add(strings.Title(name), nil, err)
+				if !continueOnError {
+					return err
+				}
+				log.Println(name, "skipped:", err)
+				failed = append(failed, name)
+				lastErr = err
+				continue
+			}
+			defer job.release()
+			jobs = append(jobs, job)
+		}
 
 		// initialize importer
-		for _, imp := range imports {
-			err := imp.Init()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "faild to initialize", imp.Name(), ": ", err)
-				os.Exit(1)
+		for n, job := range jobs {
+			err := job.imp.Init()
+			if err == nil {
+				continue
 			}
+			err = fmt.Errorf("failed to initialize %s: %w", job.imp.Name(), err)
+			report.Add(job.imp.Name(), nil, err)
+			if !continueOnError {
+				return err
+			}
+			log.Println(err)
+			failed = append(failed, job.imp.Name())
+			lastErr = err
+			jobs[n] = nil
+		}
+
+		// with duplicate detection the records of all importers are needed
+		// before anything is exported
+		var output mip.RecordWriter = export
+		buffer := &mip.RecordBuffer{}
+		if viper.IsSet("duplicates") {
+			output = buffer
 		}
 
 		// start processing
 		all_ps := mip.StartImportSummary()
 		log.Println("ALL:", "start processing")
-		for _, imp := range imports {
-			is, err := runImport(imp)
+		for _, job := range jobs {
+			if job == nil {
+				continue
+			}
+			is, err := job.run(output, report)
 			if err != nil {
-				os.Exit(1)
+				if !continueOnError {
+					return err
+				}
+				failed = append(failed, job.imp.Name())
+				lastErr = err
+				continue
 			}
 			all_ps.Add(is)
+			succeeded++
 		}
 		all_ps.Stop()
 		log.Println("ALL:", all_ps)
 
+		if succeeded == 0 && lastErr != nil {
+			// nothing succeeded, so report the cause directly
+			return lastErr
+		}
+		if output == buffer {
+			if err := writeWithoutDuplicates(export, buffer.Records); err != nil {
+				return err
+			}
+		}
+		if err := export.Commit(); err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if job == nil {
+				continue
+			}
+			if err := job.save(); err != nil {
+				return err
+			}
+		}
+		if len(failed) > 0 {
+			return &partialError{failed: failed}
+		}
+		return nil
 	},
 }
 
+// writeWithoutDuplicates writes records to export. The products which are
+// offered by more than one supplier are reported to duplicates.report_file
+// and, depending on duplicates.select, only one offer is exported.
+func writeWithoutDuplicates(export mip.RecordWriter, records []*mip.Record) error {
+	cfg := viper.Sub("duplicates")
+	if cfg == nil {
+		cfg = viper.New()
+	}
+	cfg.SetDefault("match", []string{"ean", "mpn"})
+	cfg.SetDefault("select", mip.SelectAll)
+
+	groups, err := mip.FindDuplicates(records, cfg.GetStringSlice("match"))
+	if err != nil {
+		return err
+	}
+	err = mip.SelectOffers(groups, cfg.GetString("select"), cfg.GetStringSlice("preferred"))
+	if err != nil {
+		return err
+	}
+	drop := mip.Drop(groups)
+	log.Printf("ALL: %d products offered by more than one supplier, %d offers dropped\n", len(groups), len(drop))
+	if path := cfg.GetString("report_file"); path != "" {
+		if err := mip.WriteDuplicates(path, groups); err != nil {
+			return err
+		}
+	}
+	for _, r := range records {
+		if drop[r.Key()] {
+			continue
+		}
+		if err := export.WriteRecord(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func init() {
 
+	allCmd.Flags().Bool("continue-on-error", false, "continue with the remaining importers if one fails")
+	viper.BindPFlag("continue_on_error", allCmd.Flags().Lookup("continue-on-error"))
 	RootCmd.AddCommand(allCmd)
 
 }
@@ -1,41 +1,54 @@
 package main
 
 import (
-	"fmt"
-	"github.com/dvob/mip"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"os"
 )
 
 var alltronCmd = &cobra.Command{
 	Use:   "alltron [article_file price_file]",
 	Short: "perform the alltron import",
 	Args:  ZeroOrNArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		file, err := os.Create(viper.GetString("output_file"))
+		dests, err := destinations("alltron")
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "failed to open output file: ", err)
-			os.Exit(1)
+			return err
 		}
-		defer file.Close()
-		export, err := mip.NewExport(file, viper.GetString("output_encoding"))
+		export, err := openExport(viper.GetString("output_file"), dests)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "failed to initialize export: ", err)
-			os.Exit(1)
+			return err
 		}
+		defer export.Discard()
 
+		// flags are not part of the configuration section of the importer
+		overrides := map[string]interface{}{
+			"use_ftp":        viper.GetBool("alltron.use_ftp"),
+			"ftp_save_files": viper.GetBool("alltron.ftp_save_files"),
+		}
 		// if path to files are passed by arguments set for ftp and local
 		if len(args) >= 2 {
-			viper.Set("alltron.article_file", args[0])
-			viper.Set("alltron.ftp_article_file", args[0])
-			viper.Set("alltron.price_file", args[1])
-			viper.Set("alltron.ftp_price_file", args[1])
+			overrides["article_file"] = args[0]
+			overrides["ftp_article_file"] = args[0]
+			overrides["price_file"] = args[1]
+			overrides["ftp_price_file"] = args[1]
 		}
-
-		imp := mip.NewAlltronImport(viper.Sub("alltron"), export)
-		runImport(imp)
+		job, err := newImportJob("alltron", overrides)
+		if err != nil {
+			return err
+		}
+		if err := job.acquire(); err != nil {
+			return err
+		}
+		defer job.release()
+		_, err = job.run(export, report)
+		if err != nil {
+			return err
+		}
+		if err := export.Commit(); err != nil {
+			return err
+		}
+		return job.save()
 	},
 }
 
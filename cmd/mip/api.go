@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/dvob/mip"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var apiCmd = &cobra.Command{
+	Use:   "api",
+	Short: "serve an HTTP API to run imports and fetch their results",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		tokens := viper.GetStringSlice("api.tokens")
+		if len(tokens) == 0 {
+			return mip.WrapError(mip.KindConfig, fmt.Errorf("no tokens configured in api.tokens"))
+		}
+		for n, token := range tokens {
+			// an empty token would authorize 'Authorization: Bearer '
+			if strings.TrimSpace(token) == "" {
+				return mip.WrapError(mip.KindConfig, fmt.Errorf("api.tokens[%d] is empty", n))
+			}
+		}
+		runs, err := newRunManager(viper.GetString("api.work_dir"))
+		if err != nil {
+			return err
+		}
+		addr := viper.GetString("api.listen")
+		log.Println("listen on", addr)
+		return http.ListenAndServe(addr, newAPIHandler(runs, tokens))
+	},
+}
+
+// apiHandler serves the API:
+//
+//	POST /imports/<importer>     start an import, the body is used as input file
+//	GET  /runs                   list all runs
+//	GET  /runs/<id>              get a run
+//	GET  /runs/<id>/output       download the export of a run
+//	GET  /runs/<id>/rejections   get the rejected input entries of a run
+//
+// Each request has to be authorized with one of the configured tokens
+// (Authorization: Bearer <token>).
+type apiHandler struct {
+	runs   *runManager
+	tokens []string
+	mux    *http.ServeMux
+}
+
+func newAPIHandler(runs *runManager, tokens []string) *apiHandler {
+	h := &apiHandler{
+		runs:   runs,
+		tokens: tokens,
+		mux:    http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/imports/", h.startImport)
+	h.mux.HandleFunc("/runs", h.listRuns)
+	h.mux.HandleFunc("/runs/", h.getRun)
+	return h
+}
+
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid or missing token"))
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *apiHandler) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, t := range h.tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// startImport starts the importer /imports/<importer> in the background. If
+// the request has a body it is used as input file of the importer. The
+// response is the created run.
+func (h *apiHandler) startImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	importer := strings.TrimPrefix(r.URL.Path, "/imports/")
+	if viper.Sub(importer) == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("importer '%s' not configured", importer))
+		return
+	}
+	run, err := h.runs.newRun(importer)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err := receiveInput(run, r); err != nil {
+		os.RemoveAll(run.dir)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.runs.start(run); err != nil {
+		os.RemoveAll(run.dir)
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.Header().Set("Location", "/runs/"+run.ID)
+	writeJSONResponse(w, http.StatusAccepted, h.runs.get(run.ID))
+}
+
+func (h *apiHandler) listRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, h.runs.list())
+}
+
+func (h *apiHandler) getRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/runs/"), "/")
+	run := h.runs.get(parts[0])
+	if run == nil || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run not found"))
+		return
+	}
+	if len(parts) == 1 {
+		writeJSONResponse(w, http.StatusOK, run)
+		return
+	}
+
+	if run.Status == statusRunning {
+		writeError(w, http.StatusConflict, fmt.Errorf("run not finished"))
+		return
+	}
+	switch parts[1] {
+	case "output":
+		if run.Status == mip.StatusFailed {
+			writeError(w, http.StatusNotFound, fmt.Errorf("run failed: %s", run.Error))
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		http.ServeFile(w, r, run.outputFile())
+	case "rejections":
+		if _, err := os.Stat(run.rejectionsFile()); err != nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no rejections available: %s", run.Error))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, run.rejectionsFile())
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+	}
+}
+
+// receiveInput saves the input file sent with the request r to the directory
+// of run. The file is either sent as form field 'file' of a multipart request
+// or as body. The file name of a body can be set with the query parameter
+// 'filename'. Requests without a file are ignored.
+func receiveInput(run *importRun, r *http.Request) error {
+	var (
+		body io.Reader
+		name string
+	)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		file, header, err := r.FormFile("file")
+		if err == http.ErrMissingFile {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer file.Close()
+		body, name = file, header.Filename
+	} else {
+		if r.ContentLength == 0 {
+			return nil
+		}
+		body, name = r.Body, r.URL.Query().Get("filename")
+	}
+
+	if !viper.IsSet(run.Importer + ".file") {
+		return fmt.Errorf("importer %s does not read a single file", run.Importer)
+	}
+	name = filepath.Base(name)
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = "input"
+	}
+	run.Input = filepath.Join(run.dir, name)
+	f, err := os.Create(run.Input)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func writeJSONResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("failed to write response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSONResponse(w, status, map[string]string{"error": err.Error()})
+}
+
+func init() {
+
+	viper.SetDefault("api.listen", "127.0.0.1:8081")
+	viper.SetDefault("api.work_dir", "runs")
+	RootCmd.AddCommand(apiCmd)
+
+}
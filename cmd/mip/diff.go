@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dvob/mip"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"text/tabwriter"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old> <new>",
+	Short: "report the added, removed and changed articles between two imports",
+	Long: `report the added, removed and changed articles between two imports.
+
+old and new are exports of mip. With --importer they are input files of the
+importer (e.g. two price lists of suprag) which are imported first. Only
+importers which read a single file (setting file) can be used.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		importer, _ := cmd.Flags().GetString("importer")
+		var inputs [2][]*mip.Record
+		for n, path := range args {
+			var err error
+			if importer != "" {
+				inputs[n], err = importRecords(importer, path)
+			} else {
+				inputs[n], err = readExport(path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		selling, _ := cmd.Flags().GetBool("selling-price")
+		sortBy, _ := cmd.Flags().GetString("sort")
+		desc, _ := cmd.Flags().GetBool("desc")
+		report := mip.Diff(inputs[0], inputs[1], selling)
+		if err := report.Sort(sortBy, desc); err != nil {
+			return err
+		}
+
+		if path, _ := cmd.Flags().GetString("xlsx"); path != "" {
+			if err := report.WriteXLSX(path); err != nil {
+				return err
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "id\tstate\told price\tnew price\tdelta\tdelta %\tdescription")
+		for _, c := range report.Changes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Key, c.State, c.OldPrice.Format(2), c.NewPrice.Format(2), mip.FormatDelta(c.Delta), mip.FormatPercent(c.Percent), c.Description)
+		}
+		w.Flush()
+		fmt.Printf("\n%d added, %d removed, %d changed\n", report.Count(mip.Added), report.Count(mip.Removed), report.Count(mip.Changed))
+		return nil
+	},
+}
+
+func readExport(path string) ([]*mip.Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, mip.WrapError(mip.KindSource, err)
+	}
+	defer file.Close()
+	format, err := mip.NewCSVFormat(viper.Sub("csv"))
+	if err != nil {
+		return nil, fmt.Errorf("csv: %w", err)
+	}
+	records, err := mip.ReadExport(file, viper.GetString("output_encoding"), format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return records, nil
+}
+
+// importRecords imports the file path with importer and returns the records
+// without exporting them. The sanity checks are not applied.
+func importRecords(importer, path string) ([]*mip.Record, error) {
+	// importers with more than one input file (alltron) would ignore path
+	// and import their configured source
+	cfg, err := importerConfig(importer)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.IsSet("file") {
+		return nil, mip.WrapError(mip.KindConfig, fmt.Errorf("importer %s does not read a single file", importer))
+	}
+	job, err := newImportJob(importer, map[string]interface{}{"file": path})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := job.imp.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return job.guard.Records(), nil
+}
+
+func init() {
+
+	diffCmd.Flags().String("importer", "", "compare input files of this importer instead of exports")
+	diffCmd.Flags().Bool("selling-price", false, "compare the selling instead of the purchase prices")
+	diffCmd.Flags().String("sort", "key", "sort by key, description, state, delta or percent")
+	diffCmd.Flags().Bool("desc", false, "sort in descending order")
+	diffCmd.Flags().String("xlsx", "", "write the report to this Excel file")
+	RootCmd.AddCommand(diffCmd)
+
+}
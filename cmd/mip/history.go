@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dvob/mip"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+const timeFormat = "2006-01-02 15:04"
+
+var historyCmd = &cobra.Command{
+	Use:   "history [id]",
+	Short: "show the price history of an article or the articles changed recently",
+	Long: `show the price history of an article or the articles changed recently.
+
+With an id (e.g. S-12345) the price history of the article is shown. Without
+an id the articles which appeared, disappeared or changed their price in the
+time given by --since are listed.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		path := viper.GetString("article_db")
+		if path == "" {
+			return mip.WrapError(mip.KindConfig, fmt.Errorf("article_db is not configured"))
+		}
+		if _, err := os.Stat(path); err != nil {
+			return mip.WrapError(mip.KindConfig, fmt.Errorf("article database not found: %w", err))
+		}
+		db, err := mip.OpenArticleDB(path)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if len(args) == 1 {
+			return showArticleHistory(db, args[0])
+		}
+		since, _ := cmd.Flags().GetDuration("since")
+		return showChangedArticles(db, time.Now().Add(-since))
+	},
+}
+
+func showArticleHistory(db *mip.ArticleDB, key string) error {
+	articles, err := db.Find(key)
+	if err != nil {
+		return err
+	}
+	if len(articles) == 0 {
+		return fmt.Errorf("article %s not found", key)
+	}
+	for n, a := range articles {
+		if n > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%s): %s\n", a.Key, a.Supplier, a.Description)
+		fmt.Printf("first seen: %s\n", a.FirstSeen.Format(timeFormat))
+		fmt.Printf("last seen:  %s\n", a.LastSeen.Format(timeFormat))
+		if !a.Available() {
+			fmt.Printf("gone since: %s\n", a.Gone.Format(timeFormat))
+		}
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "since\tpurchase price\tselling price\tchange\t")
+		for i, p := range a.Prices {
+			change := ""
+			if i > 0 && a.Prices[i-1].PurchasePrice != 0 {
+				change = fmt.Sprintf("%+.1f%%", (p.PurchasePrice.Float64()/a.Prices[i-1].PurchasePrice.Float64()-1)*100)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", p.Time.Format(timeFormat), p.PurchasePrice.Format(2), p.SellingPrice.Format(2), change)
+		}
+		w.Flush()
+	}
+	return nil
+}
+
+func showChangedArticles(db *mip.ArticleDB, since time.Time) error {
+	articles, err := db.ChangedSince(since)
+	if err != nil {
+		return err
+	}
+	sort.Slice(articles, func(i, j int) bool {
+		if articles[i].Supplier != articles[j].Supplier {
+			return articles[i].Supplier < articles[j].Supplier
+		}
+		return articles[i].Key < articles[j].Key
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "supplier\tid\tchange\tpurchase price\tdescription")
+	for _, a := range articles {
+		p := a.Price()
+		change := "price"
+		switch {
+		case !a.Available() && !a.Gone.Before(since):
+			change = "gone"
+		case !a.FirstSeen.Before(since):
+			change = "new"
+		case len(a.Prices) > 1:
+			prev := a.Prices[len(a.Prices)-2]
+			if prev.PurchasePrice != 0 {
+				change = fmt.Sprintf("%+.1f%%", (p.PurchasePrice.Float64()/prev.PurchasePrice.Float64()-1)*100)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Supplier, a.Key, change, p.PurchasePrice.Format(2), a.Description)
+	}
+	return w.Flush()
+}
+
+func init() {
+
+	historyCmd.Flags().Duration("since", 24*time.Hour, "list the articles changed in this time")
+	RootCmd.AddCommand(historyCmd)
+
+}
@@ -1,18 +1,37 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/dvob/mip"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"log"
 	"os"
+	"sort"
+	"strings"
+)
+
+// exit codes
+const (
+	exitOK = iota
+	exitFailure
+	exitConfig
+	exitSource
+	exitParse
+	exitOutput
+	// at least one but not all importers failed
+	exitPartial
+	exitGuard
 )
 
 var (
 	cfgFile    string
 	dumpFormat string
 
+	// report collects the results of all importers run by this invocation
+	report = mip.NewReport()
+
 	// set during build
 	version string
 	commit  string
@@ -22,6 +41,9 @@ var (
 var RootCmd = &cobra.Command{
 	Use:   "mip",
 	Short: "messerli import preparer",
+	// errors are printed in main
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
 
 var versionCmd = &cobra.Command{
@@ -38,7 +60,12 @@ var listEncCmd = &cobra.Command{
 	Use:   "list-enc",
 	Short: "list available output encodings",
 	Run: func(cmd *cobra.Command, args []string) {
-		for enc, _ := range mip.Encodings {
+		var names []string
+		for enc := range mip.Encodings {
+			names = append(names, enc)
+		}
+		sort.Strings(names)
+		for _, enc := range names {
 			fmt.Println(enc)
 		}
 	},
@@ -50,20 +77,114 @@ func init() {
 
 	RootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "config.yaml", "configuration file")
 	RootCmd.PersistentFlags().StringP("output", "o", "output.csv", "output file")
+	RootCmd.PersistentFlags().String("report", "", "write a JSON run report to this file")
+	RootCmd.PersistentFlags().Bool("skip-guards", false, "export even if the sanity checks fail")
+	RootCmd.PersistentFlags().Bool("changes-only", false, "export only the articles which are new or changed since the last run")
 
 	viper.BindPFlag("output", RootCmd.PersistentFlags().Lookup("output"))
+	viper.BindPFlag("report_file", RootCmd.PersistentFlags().Lookup("report"))
+	viper.BindPFlag("skip_guards", RootCmd.PersistentFlags().Lookup("skip-guards"))
+	viper.BindPFlag("changes_only", RootCmd.PersistentFlags().Lookup("changes-only"))
 
 	RootCmd.AddCommand(versionCmd)
 	RootCmd.AddCommand(listEncCmd)
 }
 
 func main() {
-	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	err := RootCmd.Execute()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(report.Importers) > 0 {
+		report.Finish(err)
+	}
+	if reportErr := writeReport(); reportErr != nil {
+		fmt.Fprintln(os.Stderr, reportErr)
+		if err == nil {
+			err = reportErr
+		}
+	}
+	if metricsErr := writeMetrics(); metricsErr != nil {
+		fmt.Fprintln(os.Stderr, metricsErr)
+		if err == nil {
+			err = metricsErr
+		}
+	}
+	if len(report.Importers) > 0 {
+		// notify errors are already logged
+		if notifyErr := notify(report); notifyErr != nil && err == nil {
+			err = notifyErr
+		}
+	}
+	if err != nil {
+		os.Exit(exitCode(err))
 	}
 }
 
+// writeReport writes the run report if one is configured and at least one
+// importer was run.
+func writeReport() error {
+	path := viper.GetString("report_file")
+	if path == "" || len(report.Importers) == 0 {
+		return nil
+	}
+	return report.WriteFile(path)
+}
+
+// writeMetrics writes the results of the importers as Prometheus metrics if a
+// metrics file is configured and at least one importer was run. The metrics
+// of the previous runs are read from the file, so the time of the last
+// success and the counters survive a failed run.
+func writeMetrics() error {
+	path := viper.GetString("metrics_file")
+	if path == "" || len(report.Importers) == 0 {
+		return nil
+	}
+	metrics := mip.NewMetrics()
+	if err := metrics.ReadTextfile(path); err != nil {
+		log.Println("ignoring previous metrics:", err)
+		metrics = mip.NewMetrics()
+	}
+	for _, ir := range report.Importers {
+		metrics.Observe(ir)
+	}
+	return metrics.WriteTextfile(path)
+}
+
+// exitCode returns the exit code for err. Our scheduler relies on these codes,
+// so do not change existing ones.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var pe *partialError
+	if errors.As(err, &pe) {
+		return exitPartial
+	}
+	switch mip.KindOf(err) {
+	case mip.KindConfig:
+		return exitConfig
+	case mip.KindSource:
+		return exitSource
+	case mip.KindParse:
+		return exitParse
+	case mip.KindOutput:
+		return exitOutput
+	case mip.KindGuard:
+		return exitGuard
+	}
+	return exitFailure
+}
+
+// partialError is returned if some importers failed but others succeeded.
+type partialError struct {
+	failed []string
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("importers failed: %s", strings.Join(e.failed, ", "))
+}
+
 func initConfig() {
 	if cfgFile != "" {
 		// Use config file from the flag.
@@ -75,20 +196,9 @@ func initConfig() {
 	}
 
 	if err := viper.ReadInConfig(); err != nil {
-		fmt.Println("Can't read config:", err)
-		os.Exit(1)
-	}
-}
-
-func runImport(i mip.Importer) (*mip.ImportSummary, error) {
-	log.Println(i.Name(), "start processing")
-	is, err := i.Run()
-	log.Println(i.Name(), is)
-	if err != nil {
-		log.Fatal(i.Name(), "failed", err)
+		fmt.Fprintln(os.Stderr, "Can't read config:", err)
+		os.Exit(exitConfig)
 	}
-	log.Println(i.Name(), "processing finished")
-	return is, nil
 }
 
 func ZeroOrNArgs(i int) cobra.PositionalArgs {
@@ -1,37 +1,46 @@
 package main
 
 import (
-	"fmt"
-	"github.com/dvob/mip"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"os"
 )
 
 var mitelCmd = &cobra.Command{
 	Use:   "mitel [xlsx_file]",
 	Short: "perform the mitel import",
 	Args:  ZeroOrNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		file, err := os.Create(viper.GetString("output_file"))
+		dests, err := destinations("mitel")
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "failed to open output file: ", err)
-			os.Exit(1)
+			return err
 		}
-		defer file.Close()
-		export, err := mip.NewExport(file, viper.GetString("output_encoding"))
+		export, err := openExport(viper.GetString("output_file"), dests)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "failed to initialize export: ", err)
-			os.Exit(1)
+			return err
 		}
+		defer export.Discard()
 
+		overrides := map[string]interface{}{}
 		if len(args) > 0 {
-			viper.Set("mitel.file", args[0])
+			overrides["file"] = args[0]
 		}
-
-		imp := mip.NewMitelImport(viper.Sub("mitel"), export)
-		runImport(imp)
+		job, err := newImportJob("mitel", overrides)
+		if err != nil {
+			return err
+		}
+		if err := job.acquire(); err != nil {
+			return err
+		}
+		defer job.release()
+		_, err = job.run(export, report)
+		if err != nil {
+			return err
+		}
+		if err := export.Commit(); err != nil {
+			return err
+		}
+		return job.save()
 	},
 }
 
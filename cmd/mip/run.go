@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dvob/mip"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"log"
+	"os"
+	"time"
+)
+
+// importerConfig returns the configuration section of an importer.
+func importerConfig(name string) (*viper.Viper, error) {
+	cfg := viper.Sub(name)
+	if cfg == nil {
+		return nil, mip.WrapError(mip.KindConfig, fmt.Errorf("missing configuration section '%s'", name))
+	}
+	return cfg, nil
+}
+
+// exportFile is an export to the output file. The export is written to a
+// temporary file which replaces the output file on Commit, so a failed run
+// never leaves a truncated output file behind. After that the output file is
+// delivered to the destinations.
+type exportFile struct {
+	*mip.Export
+	file         *os.File
+	path         string
+	destinations []mip.Destination
+	done         bool
+}
+
+// configList returns the entries of the list key, e.g.:
+//
+//	deliver:
+//	  - type: local
+//	    path: out.csv
+func configList(key string) []*viper.Viper {
+	var list []*viper.Viper
+	for _, entry := range cast.ToSlice(viper.Get(key)) {
+		cfg := viper.New()
+		for k, v := range cast.ToStringMap(entry) {
+			cfg.Set(k, v)
+		}
+		list = append(list, cfg)
+	}
+	return list
+}
+
+// destinations returns the destinations configured in the section deliver of
+// the importer or, if there are none, in the global section deliver. With an
+// empty importer only the global section is used.
+func destinations(importer string) ([]mip.Destination, error) {
+	key := "deliver"
+	if importer != "" && viper.IsSet(importer+".deliver") {
+		key = importer + ".deliver"
+	}
+	var list []mip.Destination
+	for n, cfg := range configList(key) {
+		d, err := mip.NewDestination(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", key, n, err)
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+// notify sends the notifications configured in the section notify about rep.
+// All notifications are tried, the first error is returned.
+func notify(rep *mip.Report) error {
+	var firstErr error
+	for n, cfg := range configList("notify") {
+		notifier, err := mip.NewNotifier(cfg)
+		if err == nil {
+			err = notifier.Notify(rep)
+		} else {
+			err = fmt.Errorf("notify[%d]: %w", n, err)
+		}
+		if err != nil {
+			log.Println(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// openExport creates the output file path and the export which writes to it.
+// On Commit the output file is delivered to destinations.
+func openExport(path string, destinations []mip.Destination) (*exportFile, error) {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, mip.WrapError(mip.KindOutput, fmt.Errorf("failed to open output file: %w", err))
+	}
+	export, err := mip.NewExport(file, viper.GetString("output_encoding"), viper.GetString("output_unmappable"))
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, mip.WrapError(mip.KindConfig, fmt.Errorf("failed to initialize export: %w", err))
+	}
+	columns, err := mip.ExportColumns(viper.GetStringSlice("output_columns"))
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	export.SetColumns(columns)
+	format, err := mip.NewCSVFormat(viper.Sub("csv"))
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("csv: %w", err)
+	}
+	export.SetFormat(format)
+	return &exportFile{Export: export, file: file, path: path, destinations: destinations}, nil
+}
+
+// Commit flushes the export, replaces the output file with it and delivers it.
+func (e *exportFile) Commit() error {
+	e.done = true
+	err := e.Export.Close()
+	if err1 := e.file.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(e.file.Name(), e.path)
+	}
+	if err != nil {
+		os.Remove(e.file.Name())
+		return mip.WrapError(mip.KindOutput, fmt.Errorf("failed to write output file: %w", err))
+	}
+	for _, d := range e.destinations {
+		log.Println("deliver", e.path, "to", d)
+	}
+	return mip.Deliver(e.path, e.destinations)
+}
+
+// Discard removes the export if it was not committed.
+func (e *exportFile) Discard() {
+	if e.done {
+		return
+	}
+	e.done = true
+	e.file.Close()
+	os.Remove(e.file.Name())
+}
+
+// importJob is an importer together with the guard which holds back its
+// records until they passed the sanity checks.
+type importJob struct {
+	name  string
+	imp   mip.Importer
+	guard *mip.Guard
+	// categories maps the categories of the records, nil without
+	// category_map
+	categories *mip.CategoryMapper
+	// converter converts the prices to the currency of the export, nil if
+	// the importer has no currency
+	converter *mip.CurrencyConverter
+
+	// lock prevents other runs of the importer, nil if not locked
+	lock *mip.Lock
+
+	// exported records and when they were imported. they are stored in the
+	// article database by save.
+	records []*mip.Record
+	time    time.Time
+}
+
+// supplierWriter sets the supplier of the records to the name of the
+// importer.
+type supplierWriter struct {
+	name string
+	w    mip.RecordWriter
+}
+
+func (s *supplierWriter) WriteRecord(r *mip.Record) error {
+	r.Supplier = s.name
+	return s.w.WriteRecord(r)
+}
+
+// newImportJob creates the job for the importer name. The settings in
+// overrides replace the ones of the configuration section of the importer.
+func newImportJob(name string, overrides map[string]interface{}) (*importJob, error) {
+	cfg, err := importerConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	// set the overrides on the section and not on the global config as
+	// viper.Sub only returns the overrides if there are any
+	for key, value := range overrides {
+		cfg.Set(key, value)
+	}
+	guardCfg := cfg.Sub("guard")
+	if viper.GetBool("skip_guards") {
+		guardCfg = nil
+	}
+	guard := mip.NewGuard(name, guardCfg, viper.GetString("history_dir"))
+	job := &importJob{
+		name:  name,
+		guard: guard,
+	}
+	var w mip.RecordWriter = guard
+	if categoryCfg := cfg.Sub("category_map"); categoryCfg != nil {
+		job.categories, err = mip.NewCategoryMapper(categoryCfg, w)
+		if err != nil {
+			return nil, fmt.Errorf("%s.category_map: %w", name, err)
+		}
+		w = job.categories
+	}
+	if cfg.IsSet("description_transforms") {
+		transforms, err := mip.NewTransforms(cast.ToSlice(cfg.Get("description_transforms")))
+		if err != nil {
+			return nil, fmt.Errorf("%s.description_transforms%w", name, err)
+		}
+		w = mip.NewTransformWriter(transforms, w)
+	}
+	if cfg.IsSet("purchase_rounding") || cfg.IsSet("selling_rounding") {
+		purchase, err := mip.NewRounding(cfg.GetString("purchase_rounding"))
+		if err != nil {
+			return nil, fmt.Errorf("%s.purchase_rounding: %w", name, err)
+		}
+		selling, err := mip.NewRounding(cfg.GetString("selling_rounding"))
+		if err != nil {
+			return nil, fmt.Errorf("%s.selling_rounding: %w", name, err)
+		}
+		// the prices are rounded after the conversion to the currency of
+		// the export
+		w = mip.NewPriceRounding(purchase, selling, w)
+	}
+	if currency := cfg.GetString("currency"); currency != "" {
+		rates, err := mip.NewExchangeRates(viper.GetString("currency"), viper.Sub("exchange_rates"))
+		if err != nil {
+			return nil, fmt.Errorf("exchange_rates: %w", err)
+		}
+		rate, err := rates.Rate(currency)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		job.converter = mip.NewCurrencyConverter(rate, w)
+		w = job.converter
+	}
+	if vatCfg := cfg.Sub("vat"); vatCfg != nil {
+		rates, err := mip.NewVATRates(viper.Sub("vat"))
+		if err != nil {
+			return nil, fmt.Errorf("vat: %w", err)
+		}
+		// the net prices are calculated before the conversion to the
+		// currency of the export
+		w, err = mip.NewVATWriter(vatCfg, rates, w)
+		if err != nil {
+			return nil, fmt.Errorf("%s.vat: %w", name, err)
+		}
+	}
+	job.imp = mip.Importers[name](cfg, &supplierWriter{name: name, w: w})
+	return job, nil
+}
+
+// run runs the importer and writes its records to export if the import
+// succeeded and passed the sanity checks. The result is added to rep.
+func (j *importJob) run(export mip.RecordWriter, rep *mip.Report) (*mip.ImportSummary, error) {
+	i := j.imp
+	log.Println(i.Name(), "start processing")
+	j.time = time.Now()
+	is, err := i.Run()
+	log.Println(i.Name(), is)
+	if j.converter != nil && is != nil {
+		is.ExchangeRate = j.converter.Rate
+		log.Println(i.Name(), "prices converted with", j.converter.Rate)
+	}
+	if j.categories != nil && is != nil {
+		is.UnmappedCategories = j.categories.Unmapped
+		if len(is.UnmappedCategories) > 0 {
+			log.Printf("%s: %d categories without category mapping\n", i.Name(), len(is.UnmappedCategories))
+		}
+	}
+	if err == nil {
+		err = j.commit(export, is)
+	}
+	rep.Add(i.Name(), is, err)
+	if err != nil {
+		log.Println(i.Name(), "failed:", err)
+		return is, fmt.Errorf("%s failed: %w", i.Name(), err)
+	}
+	log.Println(i.Name(), "processing finished")
+	return is, nil
+}
+
+// commit writes the records which passed the sanity checks to export. If
+// changes_only is set, only the records which are new or have a different
+// price than in the article database are written.
+func (j *importJob) commit(export mip.RecordWriter, is *mip.ImportSummary) error {
+	records := j.guard.Records()
+	path := viper.GetString("article_db")
+	if path == "" && viper.GetBool("changes_only") {
+		return mip.WrapError(mip.KindConfig, fmt.Errorf("changes_only requires an article_db"))
+	}
+	if path != "" {
+		db, err := mip.OpenArticleDB(path)
+		if err != nil {
+			return err
+		}
+		changes, err := db.Changes(j.name, records)
+		db.Close()
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: %d new, %d removed and %d changed articles\n", j.imp.Name(), len(changes.Added), len(changes.Removed), len(changes.Changed))
+		if viper.GetBool("changes_only") {
+			export = changes.Filter(export)
+		}
+	}
+	if err := j.guard.Commit(export, is); err != nil {
+		return err
+	}
+	j.records = records
+	return nil
+}
+
+// acquire locks the importer, so no other process (e.g. a run started by cron
+// and 'mip serve') writes the same output file and history at the same time.
+// The lock file is in the history_dir.
+func (j *importJob) acquire() error {
+	lock, err := mip.AcquireLock(viper.GetString("history_dir"), j.name)
+	if err != nil {
+		return err
+	}
+	j.lock = lock
+	return nil
+}
+
+// release releases the lock of acquire.
+func (j *importJob) release() {
+	if err := j.lock.Release(); err != nil {
+		log.Println(j.name, "failed to release lock:", err)
+	}
+	j.lock = nil
+}
+
+// save stores the run as guard history and the exported records in the
+// article database. It has to be called after the export was written, so a
+// failed export does not count as seen.
+func (j *importJob) save() error {
+	if err := j.guard.SaveHistory(); err != nil {
+		return err
+	}
+	path := viper.GetString("article_db")
+	if path == "" || j.records == nil {
+		return nil
+	}
+	db, err := mip.OpenArticleDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Record(j.name, j.time, j.records)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dvob/mip"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const statusRunning = "running"
+
+// files in the directory of a run
+const (
+	runFile        = "run.json"
+	rejectionsFile = "rejections.json"
+	outputFile     = "output.csv"
+)
+
+// importRun is an import started on request (e.g. over the web interface or
+// the API). Each run has its own directory with the uploaded input, the
+// export, the rejections and the run itself, so the runs survive a restart.
+// The records and rejections are only kept in the directory and read when
+// they are needed.
+type importRun struct {
+	ID       string      `json:"id"`
+	Importer string      `json:"importer"`
+	Input    string      `json:"input,omitempty"`
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Started  time.Time   `json:"started"`
+	Finished time.Time   `json:"finished"`
+	Report   *mip.Report `json:"report,omitempty"`
+
+	dir string
+}
+
+func (r *importRun) outputFile() string {
+	return filepath.Join(r.dir, outputFile)
+}
+
+func (r *importRun) rejectionsFile() string {
+	return filepath.Join(r.dir, rejectionsFile)
+}
+
+// importer returns the result of the importer of the run, nil if the run
+// has not finished or failed before the import.
+func (r *importRun) importer() *mip.ImporterReport {
+	if r.Report == nil || len(r.Report.Importers) == 0 {
+		return nil
+	}
+	return r.Report.Importers[0]
+}
+
+// records reads the exported records of the run from its directory. A run
+// without export has no records.
+func (r *importRun) records() ([]*mip.Record, error) {
+	if r.Status != mip.StatusOK && r.Status != mip.StatusPartial {
+		return nil, nil
+	}
+	file, err := os.Open(r.outputFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	format, err := mip.NewCSVFormat(viper.Sub("csv"))
+	if err != nil {
+		return nil, err
+	}
+	return mip.ReadExport(file, viper.GetString("output_encoding"), format)
+}
+
+// rejections reads the rejections of the run from its directory.
+func (r *importRun) rejections() ([]*mip.Rejection, error) {
+	content, err := ioutil.ReadFile(r.rejectionsFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rejections []*mip.Rejection
+	if err := json.Unmarshal(content, &rejections); err != nil {
+		return nil, fmt.Errorf("%s: %w", r.rejectionsFile(), err)
+	}
+	return rejections, nil
+}
+
+// save writes the run to its directory.
+func (r *importRun) save() error {
+	return writeJSON(filepath.Join(r.dir, runFile), r)
+}
+
+// saveRejections writes the rejections of the import to the directory of the
+// run.
+func (r *importRun) saveRejections(rejections []*mip.Rejection) error {
+	if rejections == nil {
+		rejections = []*mip.Rejection{}
+	}
+	return writeJSON(r.rejectionsFile(), rejections)
+}
+
+// loadRun loads the run saved in dir.
+func loadRun(dir string) (*importRun, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, runFile))
+	if err != nil {
+		return nil, err
+	}
+	run := &importRun{}
+	if err := json.Unmarshal(content, run); err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	run.dir = dir
+	return run, nil
+}
+
+// writeJSON writes v atomically to path.
+func writeJSON(path string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runManager starts import runs in the background and keeps them. An
+// importer can only run once at a time. Only the keepRuns most recent runs
+// are kept in memory, older runs are loaded from their directory on request.
+type runManager struct {
+	dir string
+
+	mu      sync.Mutex
+	runs    map[string]*importRun
+	order   []string
+	running map[string]bool
+}
+
+// newRunManager returns a runManager which keeps its runs in dir. The runs of
+// previous invocations are loaded from dir.
+func newRunManager(dir string) (*runManager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, mip.WrapError(mip.KindOutput, err)
+	}
+	m := &runManager{
+		dir:     dir,
+		runs:    make(map[string]*importRun),
+		running: make(map[string]bool),
+	}
+	if err := m.load(); err != nil {
+		return nil, mip.WrapError(mip.KindConfig, fmt.Errorf("failed to load runs: %w", err))
+	}
+	return m, nil
+}
+
+// load loads the runs saved in the directory of m.
+func (m *runManager) load() error {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if !fi.IsDir() {
+			continue
+		}
+		run, err := loadRun(filepath.Join(m.dir, fi.Name()))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		// we were stopped during the run
+		if run.Status == statusRunning {
+			run.Status = mip.StatusFailed
+			run.Error = "interrupted"
+			if err := run.save(); err != nil {
+				return err
+			}
+		}
+		m.runs[run.ID] = run
+		m.order = append(m.order, run.ID)
+	}
+	sort.Slice(m.order, func(i, j int) bool {
+		return m.runs[m.order[i]].Started.Before(m.runs[m.order[j]].Started)
+	})
+	m.trim()
+	return nil
+}
+
+// trim removes the oldest finished runs from memory if there are more than
+// keepRuns. It has to be called with mu locked.
+func (m *runManager) trim() {
+	for n := 0; len(m.order) > keepRuns && n < len(m.order); {
+		run := m.runs[m.order[n]]
+		if run.Status == statusRunning {
+			n++
+			continue
+		}
+		delete(m.runs, run.ID)
+		m.order = append(m.order[:n], m.order[n+1:]...)
+	}
+}
+
+// newRun creates a run for importer and its directory. The run has to be
+// started with start.
+func (m *runManager) newRun(importer string) (*importRun, error) {
+	if _, ok := mip.Importers[importer]; !ok {
+		return nil, mip.WrapError(mip.KindConfig, fmt.Errorf("unknown importer '%s'", importer))
+	}
+	random := make([]byte, 4)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	run := &importRun{
+		ID:       time.Now().Format("20060102-150405-") + hex.EncodeToString(random),
+		Importer: importer,
+	}
+	run.dir = filepath.Join(m.dir, run.ID)
+	if err := os.MkdirAll(run.dir, 0755); err != nil {
+		return nil, mip.WrapError(mip.KindOutput, err)
+	}
+	return run, nil
+}
+
+// start runs run in the background.
+func (m *runManager) start(run *importRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running[run.Importer] {
+		return fmt.Errorf("%s is already running", run.Importer)
+	}
+	m.running[run.Importer] = true
+	run.Status = statusRunning
+	run.Started = time.Now()
+	m.runs[run.ID] = run
+	m.order = append(m.order, run.ID)
+	m.trim()
+	if err := run.save(); err != nil {
+		log.Println("failed to save run:", err)
+	}
+	go m.execute(run)
+	return nil
+}
+
+func (m *runManager) execute(run *importRun) {
+	var overrides map[string]interface{}
+	if run.Input != "" {
+		overrides = map[string]interface{}{"file": run.Input}
+	}
+	rep := mip.NewReport()
+	is, err := runToFile(run.Importer, overrides, run.outputFile(), false, rep)
+	rep.Finish(err)
+	if is != nil {
+		if err := run.saveRejections(is.Rejections); err != nil {
+			log.Println("failed to save rejections:", err)
+		}
+	}
+	// the rejections are read from the file when they are needed
+	for _, ir := range rep.Importers {
+		ir.Rejections = nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.running, run.Importer)
+	run.Finished = time.Now()
+	run.Status = rep.Status
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.Report = rep
+	if err := run.save(); err != nil {
+		log.Println("failed to save run:", err)
+	}
+}
+
+// get returns a snapshot of the run id or nil if there is no such run. Runs
+// which are no longer in memory are loaded from their directory.
+func (m *runManager) get(id string) *importRun {
+	m.mu.Lock()
+	run, ok := m.runs[id]
+	if ok {
+		snapshot := *run
+		m.mu.Unlock()
+		return &snapshot
+	}
+	m.mu.Unlock()
+	// the id must not leave the directory of the runs
+	if id == "" || id != filepath.Base(id) || id == "." || id == ".." {
+		return nil
+	}
+	run, err := loadRun(filepath.Join(m.dir, id))
+	if err != nil {
+		return nil
+	}
+	if run.Status == statusRunning {
+		// runs which are not in memory are no longer running
+		run.Status = mip.StatusFailed
+		run.Error = "interrupted"
+	}
+	return run
+}
+
+// list returns a snapshot of all runs, the most recent first.
+func (m *runManager) list() []*importRun {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	runs := make([]*importRun, 0, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		snapshot := *m.runs[m.order[i]]
+		runs = append(runs, &snapshot)
+	}
+	return runs
+}
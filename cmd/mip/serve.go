@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dvob/mip"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// number of runs kept in memory for the /runs endpoint
+const keepRuns = 100
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "run the importers periodically according to their schedule",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		s := newServer()
+		c := cron.New()
+		for _, name := range mip.ImporterNames() {
+			cfg := viper.Sub(name)
+			if cfg == nil || cfg.GetString("schedule") == "" {
+				continue
+			}
+			if cfg.GetString("output_file") == "" {
+				return mip.WrapError(mip.KindConfig, fmt.Errorf("%s: output_file is required if a schedule is set", name))
+			}
+			name := name
+			_, err := c.AddFunc(cfg.GetString("schedule"), func() { s.run(name) })
+			if err != nil {
+				return mip.WrapError(mip.KindConfig, fmt.Errorf("%s: invalid schedule: %w", name, err))
+			}
+			log.Printf("schedule %s at '%s'\n", name, cfg.GetString("schedule"))
+		}
+		if len(c.Entries()) == 0 {
+			return mip.WrapError(mip.KindConfig, fmt.Errorf("no importer has a schedule"))
+		}
+
+		if addr := viper.GetString("serve.listen"); addr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", s.metrics)
+			mux.HandleFunc("/runs", s.serveRuns)
+			go func() {
+				log.Println("listen on", addr)
+				if err := http.ListenAndServe(addr, mux); err != nil {
+					log.Fatal(err)
+				}
+			}()
+		}
+
+		c.Start()
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Println("stop scheduler. wait for running imports")
+		<-c.Stop().Done()
+		return nil
+	},
+}
+
+// server runs the scheduled imports and keeps their history.
+type server struct {
+	metrics *mip.Metrics
+
+	mu      sync.Mutex
+	running map[string]bool
+	runs    []*mip.Report
+}
+
+func newServer() *server {
+	s := &server{
+		metrics: mip.NewMetrics(),
+		running: make(map[string]bool),
+		runs:    []*mip.Report{},
+	}
+	// continue with the metrics of the previous runs
+	if path := viper.GetString("metrics_file"); path != "" {
+		if err := s.metrics.ReadTextfile(path); err != nil {
+			log.Println("ignoring previous metrics:", err)
+			s.metrics = mip.NewMetrics()
+		}
+	}
+	return s
+}
+
+// lock marks the importer name as running. It returns false if the importer
+// is already running.
+func (s *server) lock(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[name] {
+		return false
+	}
+	s.running[name] = true
+	return true
+}
+
+func (s *server) unlock(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, name)
+}
+
+// run runs the importer name and writes its export to the output file of the
+// importer.
+func (s *server) run(name string) {
+	if !s.lock(name) {
+		log.Println(name, "is still running. skip run")
+		return
+	}
+	defer s.unlock(name)
+
+	rep := mip.NewReport()
+	_, err := runToFile(name, nil, viper.GetString(name+".output_file"), true, rep)
+	rep.Finish(err)
+	if err != nil {
+		log.Println(name, "failed:", err)
+	}
+	for _, ir := range rep.Importers {
+		s.metrics.Observe(ir)
+	}
+	if err := s.record(rep); err != nil {
+		log.Println(err)
+	}
+	// errors are logged by notify
+	notify(rep)
+}
+
+// record adds a finished run to the history.
+func (s *server) record(rep *mip.Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runs = append(s.runs, rep)
+	if len(s.runs) > keepRuns {
+		s.runs = s.runs[len(s.runs)-keepRuns:]
+	}
+
+	if path := viper.GetString("metrics_file"); path != "" {
+		if err := s.metrics.WriteTextfile(path); err != nil {
+			return err
+		}
+	}
+
+	path := viper.GetString("serve.history_file")
+	if path == "" {
+		return nil
+	}
+	content, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write run history: %w", err)
+	}
+	_, err = file.Write(append(content, '\n'))
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write run history: %w", err)
+	}
+	return nil
+}
+
+// serveRuns serves the most recent runs as JSON.
+func (s *server) serveRuns(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.runs)
+}
+
+// runToFile runs the importer name and writes its export to path. If deliver
+// is set the export is delivered to the destinations of the importer and the
+// run is stored in the guard history and the article database. Without
+// deliver the run is a preview which changes nothing but path. The result is
+// added to rep. See newImportJob for overrides.
+func runToFile(name string, overrides map[string]interface{}, path string, deliver bool, rep *mip.Report) (*mip.ImportSummary, error) {
+	var dests []mip.Destination
+	if deliver {
+		var err error
+		dests, err = destinations(name)
+		if err != nil {
+			rep.Add(strings.Title(name), nil, err)
+			return nil, err
+		}
+	}
+	export, err := openExport(path, dests)
+	if err != nil {
+		rep.Add(strings.Title(name), nil, err)
+		return nil, err
+	}
+	defer export.Discard()
+
+	job, err := newImportJob(name, overrides)
+	if err == nil && deliver {
+		// a preview writes nothing which other runs of the importer use
+		err = job.acquire()
+	}
+	if err != nil {
+		rep.Add(strings.Title(name), nil, err)
+		return nil, err
+	}
+	if job.lock != nil {
+		defer job.release()
+	}
+	is, err := job.run(export, rep)
+	if err != nil {
+		return is, err
+	}
+	if err := export.Commit(); err != nil {
+		return is, err
+	}
+	if !deliver {
+		return is, nil
+	}
+	return is, job.save()
+}
+
+func init() {
+
+	RootCmd.AddCommand(serveCmd)
+
+}
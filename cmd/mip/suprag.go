@@ -1,37 +1,46 @@
 package main
 
 import (
-	"fmt"
-	"github.com/dvob/mip"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"os"
 )
 
 var supragCmd = &cobra.Command{
 	Use:   "suprag [xlsx_file]",
 	Short: "perform the suprag import",
 	Args:  ZeroOrNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		file, err := os.Create(viper.GetString("output_file"))
+		dests, err := destinations("suprag")
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "failed to open output file: ", err)
-			os.Exit(1)
+			return err
 		}
-		defer file.Close()
-		export, err := mip.NewExport(file, viper.GetString("output_encoding"))
+		export, err := openExport(viper.GetString("output_file"), dests)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "failed to initialize export: ", err)
-			os.Exit(1)
+			return err
 		}
+		defer export.Discard()
 
+		overrides := map[string]interface{}{}
 		if len(args) > 0 {
-			viper.Set("suprag.file", args[0])
+			overrides["file"] = args[0]
 		}
-
-		imp := mip.NewSupragImport(viper.Sub("suprag"), export)
-		runImport(imp)
+		job, err := newImportJob("suprag", overrides)
+		if err != nil {
+			return err
+		}
+		if err := job.acquire(); err != nil {
+			return err
+		}
+		defer job.release()
+		_, err = job.run(export, report)
+		if err != nil {
+			return err
+		}
+		if err := export.Commit(); err != nil {
+			return err
+		}
+		return job.save()
 	},
 }
 
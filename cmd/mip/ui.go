@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dvob/mip"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maximal number of records shown in the preview
+const previewLimit = 200
+
+var uiCmd = &cobra.Command{
+	Use:   "ui",
+	Short: "serve a local web interface to run imports and inspect the results",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		runs, err := newRunManager(viper.GetString("ui.work_dir"))
+		if err != nil {
+			return err
+		}
+		addr := viper.GetString("ui.listen")
+		log.Printf("open http://%s in your browser\n", addr)
+		return http.ListenAndServe(addr, newUIHandler(runs))
+	},
+}
+
+type uiHandler struct {
+	runs *runManager
+	mux  *http.ServeMux
+}
+
+func newUIHandler(runs *runManager) *uiHandler {
+	h := &uiHandler{
+		runs: runs,
+		mux:  http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/", h.index)
+	h.mux.HandleFunc("/run/", h.startRun)
+	h.mux.HandleFunc("/runs/", h.showRun)
+	return h
+}
+
+func (h *uiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// uiImporter is an importer as shown in the web interface.
+type uiImporter struct {
+	Name string
+	// Upload is true if the importer reads a single file which can be uploaded
+	Upload bool
+	Source string
+}
+
+func configuredImporters() []uiImporter {
+	var importers []uiImporter
+	for _, name := range mip.ImporterNames() {
+		cfg := viper.Sub(name)
+		if cfg == nil {
+			continue
+		}
+		imp := uiImporter{
+			Name:   name,
+			Upload: cfg.IsSet("file"),
+			Source: cfg.GetString("file"),
+		}
+		if !imp.Upload {
+			imp.Source = cfg.GetString("article_file") + ", " + cfg.GetString("price_file")
+			if cfg.GetBool("use_ftp") {
+				imp.Source = cfg.GetString("ftp_address")
+			}
+		}
+		importers = append(importers, imp)
+	}
+	return importers
+}
+
+func (h *uiHandler) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	render(w, indexTemplate, map[string]interface{}{
+		"Importers": configuredImporters(),
+		"Runs":      h.runs.list(),
+	})
+}
+
+// startRun starts a run of the importer /run/<importer> with the uploaded file
+// if there is one.
+func (h *uiHandler) startRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	run, err := h.runs.newRun(strings.TrimPrefix(r.URL.Path, "/run/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := receiveInput(run, r); err != nil {
+		os.RemoveAll(run.dir)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.runs.start(run); err != nil {
+		os.RemoveAll(run.dir)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Redirect(w, r, "/runs/"+run.ID, http.StatusSeeOther)
+}
+
+// showRun shows the run /runs/<id> or downloads its export on
+// /runs/<id>/output.
+func (h *uiHandler) showRun(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/runs/"), "/")
+	run := h.runs.get(parts[0])
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) > 1 && parts[1] == "output" {
+		if run.Status == statusRunning {
+			http.Error(w, "run not finished", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s.csv\"", run.Importer, run.ID))
+		http.ServeFile(w, r, run.outputFile())
+		return
+	}
+
+	all, err := run.records()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read records: %s", err), http.StatusInternalServerError)
+		return
+	}
+	rejections, err := run.rejections()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read rejections: %s", err), http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	records := filterRecords(all, query)
+	data := map[string]interface{}{
+		"Run":        run,
+		"Importer":   run.importer(),
+		"Rejections": rejections,
+		"Query":      query,
+		"Total":      len(all),
+		"Records":    limitRecords(records, previewLimit),
+		"Matches":    len(records),
+		"Truncated":  len(records) > previewLimit,
+	}
+	if ir := run.importer(); ir != nil {
+		data["Ignored"] = sortedCounts(ir.IgnoredByReason)
+		data["Skipped"] = sortedCounts(ir.SkippedByReason)
+		data["Unmapped"] = sortedCounts(ir.UnmappedCategories)
+	}
+	render(w, runTemplate, data)
+}
+
+// filterRecords returns the records whose id or description contains query
+// (case insensitive).
+func filterRecords(records []*mip.Record, query string) []*mip.Record {
+	if query == "" {
+		return records
+	}
+	query = strings.ToLower(query)
+	var matches []*mip.Record
+	for _, r := range records {
+		if strings.Contains(strings.ToLower(r.Key()), query) || strings.Contains(strings.ToLower(r.Description), query) {
+			matches = append(matches, r)
+		}
+	}
+	return matches
+}
+
+func limitRecords(records []*mip.Record, n int) []*mip.Record {
+	if len(records) > n {
+		return records[:n]
+	}
+	return records
+}
+
+type reasonCount struct {
+	Reason string
+	Count  int
+}
+
+// sortedCounts returns counts sorted by reason.
+func sortedCounts(counts map[string]int) []reasonCount {
+	var list []reasonCount
+	for reason, n := range counts {
+		list = append(list, reasonCount{reason, n})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Reason < list[j].Reason })
+	return list
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.ExecuteTemplate(w, "page", data); err != nil {
+		log.Println("failed to render page:", err)
+	}
+}
+
+const layoutHTML = `{{define "page"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mip</title>
+{{block "head" .}}{{end}}
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+th { background: #eee; }
+td.num { text-align: right; }
+.ok { color: #080; } .failed { color: #b00; } .partial { color: #b60; } .running { color: #06b; }
+</style>
+</head>
+<body>
+<h1><a href="/">mip</a> &ndash; messerli import preparer</h1>
+{{template "content" .}}
+</body>
+</html>{{end}}`
+
+var indexTemplate = template.Must(template.Must(template.New("index").Parse(layoutHTML)).Parse(`
+{{define "content"}}
+<h2>Importers</h2>
+<table>
+<tr><th>Importer</th><th>Source</th><th>Run</th></tr>
+{{range .Importers}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{.Source}}</td>
+<td>
+<form method="post" action="/run/{{.Name}}" enctype="multipart/form-data">
+{{if .Upload}}<input type="file" name="file"> {{end}}
+<button type="submit">Run import</button>
+</form>
+</td>
+</tr>
+{{end}}
+</table>
+<p>If no file is uploaded the configured source is used.</p>
+
+<h2>Runs</h2>
+<table>
+<tr><th>Run</th><th>Importer</th><th>Started</th><th>Status</th></tr>
+{{range .Runs}}
+<tr>
+<td><a href="/runs/{{.ID}}">{{.ID}}</a></td>
+<td>{{.Importer}}</td>
+<td>{{.Started.Format "2006-01-02 15:04:05"}}</td>
+<td class="{{.Status}}">{{.Status}}</td>
+</tr>
+{{else}}
+<tr><td colspan="4">no runs yet</td></tr>
+{{end}}
+</table>
+{{end}}`))
+
+var runTemplate = template.Must(template.Must(template.New("run").Parse(layoutHTML)).Parse(`
+{{define "head"}}{{if eq .Run.Status "running"}}<meta http-equiv="refresh" content="2">{{end}}{{end}}
+{{define "content"}}
+{{with .Run}}
+<h2>Run {{.ID}}</h2>
+<table>
+<tr><th>Importer</th><td>{{.Importer}}</td></tr>
+{{if .Input}}<tr><th>Input</th><td>{{.Input}}</td></tr>{{end}}
+<tr><th>Started</th><td>{{.Started.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Status</th><td class="{{.Status}}">{{.Status}}</td></tr>
+{{if .Error}}<tr><th>Error</th><td class="failed">{{.Error}}</td></tr>{{end}}
+{{with $.Importer}}
+<tr><th>Articles</th><td class="num">{{.Articles}}</td></tr>
+<tr><th>Ignored</th><td class="num">{{.Ignored}}</td></tr>
+<tr><th>Skipped</th><td class="num">{{.Skipped}}</td></tr>
+<tr><th>Duration</th><td>{{printf "%.1fs" .Duration}}</td></tr>
+{{end}}
+</table>
+{{if eq .Status "ok" "partial"}}<p><a href="/runs/{{.ID}}/output">Download export</a></p>{{end}}
+{{end}}
+
+{{if .Ignored}}
+<h3>Ignored articles</h3>
+<table>
+<tr><th>Reason</th><th>Articles</th></tr>
+{{range .Ignored}}<tr><td>{{.Reason}}</td><td class="num">{{.Count}}</td></tr>{{end}}
+</table>
+{{end}}
+
+{{if .Skipped}}
+<h3>Skipped articles</h3>
+<table>
+<tr><th>Reason</th><th>Articles</th></tr>
+{{range .Skipped}}<tr><td>{{.Reason}}</td><td class="num">{{.Count}}</td></tr>{{end}}
+</table>
+{{end}}
+
+{{if .Unmapped}}
+<h3>Categories without mapping</h3>
+<table>
+<tr><th>Supplier category</th><th>Articles</th></tr>
+{{range .Unmapped}}<tr><td>{{.Reason}}</td><td class="num">{{.Count}}</td></tr>{{end}}
+</table>
+{{end}}
+
+{{if .Rejections}}
+<h3>Rejected rows</h3>
+<table>
+<tr><th>Position</th><th>Id</th><th>Reason</th><th>Value</th></tr>
+{{range .Rejections}}<tr><td>{{.Ref}}</td><td>{{.Id}}</td><td>{{.Reason}}</td><td>{{.Detail}}</td></tr>{{end}}
+</table>
+{{end}}
+
+{{if .Total}}
+<h3>Records</h3>
+<form method="get">
+<input type="search" name="q" value="{{.Query}}" placeholder="search id or description">
+<button type="submit">Search</button>
+</form>
+<p>{{.Matches}} records{{if .Truncated}}, showing the first {{len .Records}}{{end}}</p>
+<table>
+<tr><th>Id</th><th>Description</th><th>Category</th><th>Purchase price</th><th>Selling price</th></tr>
+{{range .Records}}
+<tr>
+<td>{{.Key}}</td>
+<td>{{.Description}}</td>
+<td>{{.Category}} {{.CategoryNumber}}</td>
+<td class="num">{{.PurchasePrice.Format 2}}</td>
+<td class="num">{{.SellingPrice.Format 2}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+{{end}}`))
+
+func init() {
+
+	viper.SetDefault("ui.listen", "127.0.0.1:8080")
+	viper.SetDefault("ui.work_dir", "runs")
+	RootCmd.AddCommand(uiCmd)
+
+}
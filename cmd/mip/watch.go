@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dvob/mip"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	processedDir = "processed"
+	failedDir    = "failed"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <dir>",
+	Short: "import the supplier files dropped into a directory",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		w, err := newFolderWatcher(args[0])
+		if err != nil {
+			return err
+		}
+		return w.watch()
+	},
+}
+
+// folderWatcher imports the files dropped into a directory. The importer is
+// selected by the file name. After the import the file is moved to the
+// directory processed or failed together with the report of the import.
+type folderWatcher struct {
+	dir      string
+	patterns map[string]string
+	settle   time.Duration
+
+	// pending files with the time of the last change and their size
+	pending map[string]time.Time
+	sizes   map[string]int64
+}
+
+func newFolderWatcher(dir string) (*folderWatcher, error) {
+	patterns := viper.GetStringMapString("watch.patterns")
+	if len(patterns) == 0 {
+		return nil, mip.WrapError(mip.KindConfig, fmt.Errorf("no patterns configured in watch.patterns"))
+	}
+	for name, pattern := range patterns {
+		cfg, err := importerConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		// importers with more than one input file (alltron) can not be
+		// used with a single dropped file
+		if !cfg.IsSet("file") {
+			return nil, mip.WrapError(mip.KindConfig, fmt.Errorf("importer %s does not read a single file", name))
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, mip.WrapError(mip.KindConfig, fmt.Errorf("invalid pattern for %s: %w", name, err))
+		}
+	}
+	for _, sub := range []string{processedDir, failedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, mip.WrapError(mip.KindOutput, err)
+		}
+	}
+	settle := viper.GetDuration("watch.settle_time")
+	if settle == 0 {
+		settle = 5 * time.Second
+	}
+	return &folderWatcher{
+		dir:      dir,
+		patterns: patterns,
+		settle:   settle,
+		pending:  make(map[string]time.Time),
+		sizes:    make(map[string]int64),
+	}, nil
+}
+
+func (w *folderWatcher) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(w.dir); err != nil {
+		return mip.WrapError(mip.KindConfig, fmt.Errorf("failed to watch %s: %w", w.dir, err))
+	}
+	log.Println("watch", w.dir)
+
+	// files dropped while we were not running
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return mip.WrapError(mip.KindSource, err)
+	}
+	for _, fi := range files {
+		if !fi.IsDir() {
+			w.changed(filepath.Join(w.dir, fi.Name()))
+		}
+	}
+
+	ticker := time.NewTicker(w.settle / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+				w.changed(event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Println("watch error:", err)
+		case <-ticker.C:
+			w.processSettled()
+		}
+	}
+}
+
+// changed registers a change of a file.
+func (w *folderWatcher) changed(path string) {
+	if filepath.Dir(path) != filepath.Clean(w.dir) || w.importerFor(path) == "" {
+		return
+	}
+	w.pending[path] = time.Now()
+}
+
+// importerFor returns the importer whose pattern matches the file name of
+// path or an empty string if no pattern matches.
+func (w *folderWatcher) importerFor(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+	// lock and temporary files of office applications
+	if strings.HasPrefix(name, "~$") || strings.HasPrefix(name, ".") {
+		return ""
+	}
+	importers := make([]string, 0, len(w.patterns))
+	for importer := range w.patterns {
+		importers = append(importers, importer)
+	}
+	sort.Strings(importers)
+	for _, importer := range importers {
+		if ok, _ := filepath.Match(strings.ToLower(w.patterns[importer]), name); ok {
+			return importer
+		}
+	}
+	return ""
+}
+
+// processSettled imports the pending files which did not change for the
+// settle time.
+func (w *folderWatcher) processSettled() {
+	var settled []string
+	for path, last := range w.pending {
+		if time.Since(last) < w.settle {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			// removed again
+			delete(w.pending, path)
+			delete(w.sizes, path)
+			continue
+		}
+		// the file still grows without events (e.g. on network shares)
+		if size, ok := w.sizes[path]; !ok || size != fi.Size() {
+			w.sizes[path] = fi.Size()
+			w.pending[path] = time.Now()
+			continue
+		}
+		settled = append(settled, path)
+	}
+	sort.Strings(settled)
+	for _, path := range settled {
+		delete(w.pending, path)
+		delete(w.sizes, path)
+		w.process(path)
+	}
+}
+
+// process imports the file path and moves it to the processed or failed
+// directory afterwards.
+func (w *folderWatcher) process(path string) {
+	importer := w.importerFor(path)
+	log.Printf("import %s with %s\n", path, importer)
+
+	output := viper.GetString(importer + ".output_file")
+	if output == "" {
+		output = viper.GetString("output_file")
+	}
+	rep := mip.NewReport()
+	_, err := runToFile(importer, map[string]interface{}{"file": path}, output, true, rep)
+	rep.Finish(err)
+	// errors are logged by notify
+	notify(rep)
+
+	target := processedDir
+	if err != nil {
+		log.Println(path, "failed:", err)
+		target = failedDir
+	}
+	base := time.Now().Format("20060102-150405-") + filepath.Base(path)
+	dest := filepath.Join(w.dir, target, base)
+	if err := os.Rename(path, dest); err != nil {
+		log.Println("failed to move input:", err)
+		return
+	}
+	if err := rep.WriteFile(dest + ".report.json"); err != nil {
+		log.Println(err)
+	}
+	log.Println("moved", path, "to", dest)
+}
+
+func init() {
+
+	RootCmd.AddCommand(watchCmd)
+
+}
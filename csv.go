@@ -0,0 +1,184 @@
+package mip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+// CSVFormat is the format of the export.
+type CSVFormat struct {
+	Delimiter rune
+	// QuoteAll quotes all fields, otherwise only the fields which need it
+	QuoteAll bool
+	CRLF     bool
+	// DecimalSeparator replaces the point in numbers
+	DecimalSeparator string
+	PricePrecision   int
+	FactorPrecision  int
+	// DelimiterReplacement replaces the delimiter in the descriptions and
+	// attributes if ReplaceDelimiter is set. Messerli does not handle
+	// quoted delimiters.
+	ReplaceDelimiter     bool
+	DelimiterReplacement string
+}
+
+// DefaultCSVFormat is the format Messerli reads by default.
+var DefaultCSVFormat = &CSVFormat{
+	Delimiter:            ',',
+	QuoteAll:             true,
+	DecimalSeparator:     ".",
+	PricePrecision:       2,
+	FactorPrecision:      6,
+	ReplaceDelimiter:     true,
+	DelimiterReplacement: " ",
+}
+
+// NewCSVFormat returns the format configured in cfg. The settings which are
+// not set in cfg are the ones of DefaultCSVFormat. cfg may be nil.
+//
+//	delimiter               field delimiter (default ,)
+//	quote                   all (default) or minimal
+//	line_ending             lf (default) or crlf
+//	decimal_separator       . (default) or ,
+//	price_precision         decimal places of the prices (default 2)
+//	factor_precision        decimal places of the factors (default 6)
+//	delimiter_replacement   replaces the delimiter in the descriptions and
+//	                        attributes (default space). if it is set to an
+//	                        empty string, the delimiter is kept and the field
+//	                        is quoted
+func NewCSVFormat(cfg *viper.Viper) (*CSVFormat, error) {
+	f := *DefaultCSVFormat
+	if cfg == nil {
+		return &f, nil
+	}
+	if cfg.IsSet("delimiter") {
+		delimiter := cfg.GetString("delimiter")
+		if delimiter == "\\t" {
+			delimiter = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size == 0 || size != len(delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return nil, configErrorf("invalid delimiter '%s'", delimiter)
+		}
+		f.Delimiter = r
+	}
+	switch quote := cfg.GetString("quote"); quote {
+	case "", "all":
+	case "minimal":
+		f.QuoteAll = false
+	default:
+		return nil, configErrorf("unknown quote '%s'", quote)
+	}
+	switch ending := cfg.GetString("line_ending"); ending {
+	case "", "lf":
+	case "crlf":
+		f.CRLF = true
+	default:
+		return nil, configErrorf("unknown line_ending '%s'", ending)
+	}
+	if cfg.IsSet("decimal_separator") {
+		f.DecimalSeparator = cfg.GetString("decimal_separator")
+		if f.DecimalSeparator == "" {
+			return nil, configErrorf("invalid decimal_separator '%s'", f.DecimalSeparator)
+		}
+	}
+	for key, dst := range map[string]*int{
+		"price_precision":  &f.PricePrecision,
+		"factor_precision": &f.FactorPrecision,
+	} {
+		if !cfg.IsSet(key) {
+			continue
+		}
+		*dst = cfg.GetInt(key)
+		if *dst < 0 {
+			return nil, configErrorf("%s must not be negative", key)
+		}
+	}
+	if cfg.IsSet("delimiter_replacement") {
+		f.DelimiterReplacement = cfg.GetString("delimiter_replacement")
+		f.ReplaceDelimiter = f.DelimiterReplacement != ""
+	}
+	return &f, nil
+}
+
+func (f *CSVFormat) number(v float64, precision int) string {
+	s := strconv.FormatFloat(v, 'f', precision, 64)
+	if f.DecimalSeparator != "." {
+		s = strings.Replace(s, ".", f.DecimalSeparator, 1)
+	}
+	return s
+}
+
+// parseNumber parses a number formatted with number.
+func (f *CSVFormat) parseNumber(s string) (float64, error) {
+	return strconv.ParseFloat(f.normalizeNumber(s), 64)
+}
+
+// parsePrice parses a price formatted with price.
+func (f *CSVFormat) parsePrice(s string) (Money, error) {
+	return ParseMoney(f.normalizeNumber(s))
+}
+
+func (f *CSVFormat) normalizeNumber(s string) string {
+	if f.DecimalSeparator != "." {
+		s = strings.Replace(s, f.DecimalSeparator, ".", 1)
+	}
+	return s
+}
+
+func (f *CSVFormat) price(v Money) string {
+	s := v.Format(f.PricePrecision)
+	if f.DecimalSeparator != "." {
+		s = strings.Replace(s, ".", f.DecimalSeparator, 1)
+	}
+	return s
+}
+
+func (f *CSVFormat) factor(v float64) string {
+	return f.number(v, f.FactorPrecision)
+}
+
+// text replaces the delimiter in free text.
+func (f *CSVFormat) text(s string) string {
+	if !f.ReplaceDelimiter {
+		return s
+	}
+	return strings.Replace(s, string(f.Delimiter), f.DelimiterReplacement, -1)
+}
+
+// formatRow returns fields as a line of CSV.
+func (f *CSVFormat) formatRow(fields []string) (string, error) {
+	var b bytes.Buffer
+	if !f.QuoteAll {
+		w := csv.NewWriter(&b)
+		w.Comma = f.Delimiter
+		w.UseCRLF = f.CRLF
+		w.Write(fields)
+		w.Flush()
+		return b.String(), w.Error()
+	}
+	// encoding/csv only quotes the fields which need it, so the fields are
+	// quoted here with the same rules
+	for n, field := range fields {
+		if n > 0 {
+			b.WriteRune(f.Delimiter)
+		}
+		b.WriteByte('"')
+		if f.CRLF {
+			field = strings.Replace(strings.Replace(field, "\r\n", "\n", -1), "\n", "\r\n", -1)
+		}
+		b.WriteString(strings.Replace(field, "\"", "\"\"", -1))
+		b.WriteByte('"')
+	}
+	if f.CRLF {
+		b.WriteString("\r\n")
+	} else {
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
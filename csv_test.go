@@ -0,0 +1,67 @@
+package mip
+
+import (
+	"bytes"
+	"testing"
+)
+
+// the export of the default format has to stay byte for byte the same as
+// before the format could be configured
+func TestDefaultExport(t *testing.T) {
+	records := []*Record{
+		{
+			Id:             "100",
+			IdPrefix:       "A-",
+			Description:    `Kabel, 2m "lang"`,
+			Category:       "Alltron",
+			PurchasePrice:  95000,
+			PurchaseFactor: 1,
+			SellingPrice:   122500,
+			SellingFactor:  1.25,
+			CategoryNumber: "10.1",
+		},
+		{
+			Id:             "S1",
+			IdPrefix:       "S-",
+			Description:    "Maus",
+			Category:       "Suprag",
+			PurchasePrice:  0,
+			PurchaseFactor: 0.5,
+			SellingPrice:   10000,
+			SellingFactor:  0.333333,
+			CategoryNumber: "",
+		},
+	}
+	want := `"Id","Beschreibung","Kategorie","Einkaufspreis","Einkaufsfaktor","Verkaufspreis","Verkaufsfaktor","Kategorie-Nummer"
+"A-100","Kabel  2m ""lang""","Alltron","9.50","1.000000","12.25","1.250000","10.1"
+"S-S1","Maus","Suprag","0.00","0.500000","1.00","0.333333",""
+`
+	for _, enc := range []string{"", "utf8", "iso-8859-1"} {
+		var buf bytes.Buffer
+		export, err := NewExport(&buf, enc, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		format, err := NewCSVFormat(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		export.SetFormat(format)
+		columns, err := ExportColumns(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		export.SetColumns(columns)
+		for _, r := range records {
+			if err := export.WriteRecord(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := export.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("export with encoding %q:\n%s\nwant:\n%s", enc, got, want)
+		}
+	}
+}
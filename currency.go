@@ -0,0 +1,191 @@
+package mip
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// ExchangeRates converts prices between currencies.
+type ExchangeRates struct {
+	// Target is the currency of the export
+	Target string
+	// fixed are the configured rates to Target
+	fixed map[string]float64
+	// ecb are the reference rates of the European Central Bank (units per
+	// EUR), nil if there are none
+	ecb     map[string]float64
+	ecbDate string
+}
+
+// ExchangeRate is the rate used to convert the prices of an import.
+type ExchangeRate struct {
+	From string  `json:"from"`
+	To   string  `json:"to"`
+	Rate float64 `json:"rate"`
+	// Source describes where the rate is from, e.g. "config" or "ECB
+	// 2020-03-13"
+	Source string `json:"source"`
+}
+
+// NewExchangeRates returns the exchange rates to the currency target
+// configured in cfg:
+//
+//	rates     rates to target, e.g. EUR: 0.95 if 1 EUR is 0.95 CHF. they take
+//	          precedence over the ECB rates
+//	ecb_file  file with the reference rates of the ECB (eurofxref-daily.xml)
+//	ecb_url   URL to download the ECB file from. the file is downloaded if
+//	          it is older than max_age. if the download fails the existing
+//	          file is used
+//	max_age   maximal age of ecb_file (default 24h)
+//
+// cfg may be nil.
+func NewExchangeRates(target string, cfg *viper.Viper) (*ExchangeRates, error) {
+	rates := &ExchangeRates{
+		Target: strings.ToUpper(target),
+		fixed:  map[string]float64{},
+	}
+	if cfg == nil {
+		return rates, nil
+	}
+	for currency, rate := range cfg.GetStringMap("rates") {
+		value, err := cast.ToFloat64E(rate)
+		if err != nil || value <= 0 {
+			return nil, configErrorf("invalid rate for %s: %v", currency, rate)
+		}
+		rates.fixed[strings.ToUpper(currency)] = value
+	}
+	path := cfg.GetString("ecb_file")
+	if path == "" {
+		return rates, nil
+	}
+	if url := cfg.GetString("ecb_url"); url != "" {
+		maxAge := 24 * time.Hour
+		if cfg.IsSet("max_age") {
+			maxAge = cfg.GetDuration("max_age")
+		}
+		if err := refreshFile(url, path, maxAge); err != nil {
+			log.Println("using cached exchange rates:", err)
+		}
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, sourceErrorf("failed to read exchange rates: %w", err)
+	}
+	rates.ecb, rates.ecbDate, err = parseECBRates(content)
+	if err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// refreshFile downloads url to path if path is missing or older than maxAge.
+func refreshFile(url, path string, maxAge time.Duration) error {
+	if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) < maxAge {
+		return nil
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return sourceErrorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return sourceErrorf("failed to download %s: %s", url, resp.Status)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return sourceErrorf("failed to download %s: %w", url, err)
+	}
+	// do not replace a good file with an error page
+	if _, _, err := parseECBRates(content); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bytes.NewReader(content))
+}
+
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string  `xml:"currency,attr"`
+			Rate     float64 `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// parseECBRates returns the most recent rates of the ECB reference rates
+// file and their date.
+func parseECBRates(content []byte) (map[string]float64, string, error) {
+	var envelope ecbEnvelope
+	if err := xml.Unmarshal(content, &envelope); err != nil {
+		return nil, "", parseErrorf("invalid ECB rates: %w", err)
+	}
+	if len(envelope.Days) == 0 || len(envelope.Days[0].Rates) == 0 {
+		return nil, "", parseErrorf("invalid ECB rates: no rates found")
+	}
+	// the most recent day comes first
+	day := envelope.Days[0]
+	rates := map[string]float64{"EUR": 1}
+	for _, r := range day.Rates {
+		if r.Rate > 0 {
+			rates[strings.ToUpper(r.Currency)] = r.Rate
+		}
+	}
+	return rates, day.Time, nil
+}
+
+// Rate returns the rate to convert prices in the currency from to Target.
+func (e *ExchangeRates) Rate(from string) (*ExchangeRate, error) {
+	from = strings.ToUpper(from)
+	rate := &ExchangeRate{From: from, To: e.Target, Rate: 1}
+	if from == e.Target {
+		rate.Source = "same currency"
+		return rate, nil
+	}
+	if fixed, ok := e.fixed[from]; ok {
+		rate.Rate = fixed
+		rate.Source = "config"
+		return rate, nil
+	}
+	perEURFrom, ok1 := e.ecb[from]
+	perEURTarget, ok2 := e.ecb[e.Target]
+	if !ok1 || !ok2 {
+		return nil, configErrorf("no exchange rate from %s to %s", from, e.Target)
+	}
+	rate.Rate = perEURTarget / perEURFrom
+	rate.Source = "ECB " + e.ecbDate
+	return rate, nil
+}
+
+func (r *ExchangeRate) String() string {
+	return fmt.Sprintf("1 %s = %.4f %s (%s)", r.From, r.Rate, r.To, r.Source)
+}
+
+// CurrencyConverter is a RecordWriter which converts the prices of the
+// records with an exchange rate before it writes them to the next writer. All
+// records are converted with the same rate, the currency is configured per
+// importer and not read from the records.
+type CurrencyConverter struct {
+	Rate *ExchangeRate
+	w    RecordWriter
+}
+
+func NewCurrencyConverter(rate *ExchangeRate, w RecordWriter) *CurrencyConverter {
+	return &CurrencyConverter{Rate: rate, w: w}
+}
+
+func (c *CurrencyConverter) WriteRecord(r *Record) error {
+	convert := func(m Money) Money { return m.Mul(c.Rate.Rate) }
+	r.convertPrices(convert, convert)
+	return c.w.WriteRecord(r)
+}
@@ -0,0 +1,92 @@
+package mip
+
+import (
+	"math"
+	"testing"
+)
+
+const ecbRates = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<gesmes:Sender>
+		<gesmes:name>European Central Bank</gesmes:name>
+	</gesmes:Sender>
+	<Cube>
+		<Cube time="2020-03-13">
+			<Cube currency="USD" rate="1.1104"/>
+			<Cube currency="chf" rate="1.0585"/>
+			<Cube currency="XXX" rate="0"/>
+		</Cube>
+		<Cube time="2020-03-12">
+			<Cube currency="USD" rate="1.1237"/>
+			<Cube currency="CHF" rate="1.0558"/>
+			<Cube currency="GBP" rate="0.8905"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestParseECBRates(t *testing.T) {
+	rates, date, err := parseECBRates([]byte(ecbRates))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if date != "2020-03-13" {
+		t.Errorf("date = %s, want 2020-03-13", date)
+	}
+	want := map[string]float64{"EUR": 1, "USD": 1.1104, "CHF": 1.0585}
+	if len(rates) != len(want) {
+		t.Errorf("rates = %v, want %v", rates, want)
+	}
+	for currency, rate := range want {
+		if rates[currency] != rate {
+			t.Errorf("rate of %s = %v, want %v", currency, rates[currency], rate)
+		}
+	}
+}
+
+func TestParseECBRatesErrors(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"<html><body>Service unavailable</body></html>",
+		`<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01"><Cube></Cube></gesmes:Envelope>`,
+		`<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01"><Cube><Cube time="2020-03-13"></Cube></Cube></gesmes:Envelope>`,
+		"<Cube><Cube time=",
+	} {
+		if _, _, err := parseECBRates([]byte(content)); err == nil {
+			t.Errorf("parseECBRates(%q) returned no error", content)
+		} else if KindOf(err) != KindParse {
+			t.Errorf("parseECBRates(%q) returned %v, want a parse error", content, err)
+		}
+	}
+}
+
+func TestExchangeRate(t *testing.T) {
+	rates := &ExchangeRates{
+		Target:  "CHF",
+		fixed:   map[string]float64{"GBP": 1.2},
+		ecb:     map[string]float64{"EUR": 1, "USD": 1.1104, "CHF": 1.0585},
+		ecbDate: "2020-03-13",
+	}
+	for _, test := range []struct {
+		from   string
+		rate   float64
+		source string
+	}{
+		{"chf", 1, "same currency"},
+		{"GBP", 1.2, "config"},
+		{"EUR", 1.0585, "ECB 2020-03-13"},
+		{"usd", 1.0585 / 1.1104, "ECB 2020-03-13"},
+	} {
+		rate, err := rates.Rate(test.from)
+		if err != nil {
+			t.Errorf("Rate(%s): %s", test.from, err)
+			continue
+		}
+		if math.Abs(rate.Rate-test.rate) > 1e-9 || rate.Source != test.source {
+			t.Errorf("Rate(%s) = %s, want %v (%s)", test.from, rate, test.rate, test.source)
+		}
+	}
+	if _, err := rates.Rate("JPY"); err == nil {
+		t.Error("Rate(JPY) returned no error")
+	}
+}
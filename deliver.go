@@ -0,0 +1,263 @@
+package mip
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dvob/mip/ftp"
+	"github.com/spf13/viper"
+)
+
+// HTTP_TIMEOUT is the timeout of an upload to a HTTP destination.
+var HTTP_TIMEOUT = 5 * time.Minute
+
+// Destination is a place the export is delivered to (e.g. the import share of
+// Messerli). The file is delivered under a temporary name and renamed
+// afterwards where the destination supports it. If a marker is configured a
+// marker file is written after the delivery, which contains the SHA-256
+// checksum of the delivered file in the format of sha256sum.
+type Destination interface {
+	// Deliver delivers the local file path.
+	Deliver(path string) error
+	String() string
+}
+
+// NewDestination returns the destination configured in cfg:
+//
+//	type       local (default), ftp, sftp or http
+//	path       target path (local, ftp, sftp)
+//	address    host:port of the server (ftp, sftp)
+//	user       user (ftp, sftp)
+//	password   password (ftp, sftp)
+//	url        target URL (http)
+//	method     PUT (default) or POST (http)
+//	headers    additional request headers, e.g. for authentication (http)
+//	marker     suffix of the marker file (e.g. ".done"), no marker if empty
+func NewDestination(cfg *viper.Viper) (Destination, error) {
+	marker := cfg.GetString("marker")
+	switch cfg.GetString("type") {
+	case "", "local":
+		if cfg.GetString("path") == "" {
+			return nil, configErrorf("local destination: path is required")
+		}
+		return &localDestination{path: cfg.GetString("path"), marker: marker}, nil
+	case "ftp", "sftp":
+		d := &ftpDestination{
+			sftp:     cfg.GetString("type") == "sftp",
+			addr:     cfg.GetString("address"),
+			user:     cfg.GetString("user"),
+			password: cfg.GetString("password"),
+			path:     cfg.GetString("path"),
+			marker:   marker,
+		}
+		if d.addr == "" || d.path == "" {
+			return nil, configErrorf("%s destination: address and path are required", cfg.GetString("type"))
+		}
+		return d, nil
+	case "http":
+		d := &httpDestination{
+			url:     cfg.GetString("url"),
+			method:  strings.ToUpper(cfg.GetString("method")),
+			headers: cfg.GetStringMapString("headers"),
+			marker:  marker,
+		}
+		if d.method == "" {
+			d.method = http.MethodPut
+		}
+		if d.url == "" {
+			return nil, configErrorf("http destination: url is required")
+		}
+		if d.method != http.MethodPut && d.method != http.MethodPost {
+			return nil, configErrorf("http destination: unsupported method '%s'", d.method)
+		}
+		return d, nil
+	}
+	return nil, configErrorf("unknown destination type '%s'", cfg.GetString("type"))
+}
+
+// Deliver delivers the file path to all destinations. It stops at the first
+// failed delivery.
+func Deliver(path string, destinations []Destination) error {
+	for _, d := range destinations {
+		if err := d.Deliver(path); err != nil {
+			return outputErrorf("failed to deliver %s to %s: %w", path, d, err)
+		}
+	}
+	return nil
+}
+
+// markerContent returns the content of the marker file for the file path
+// delivered as name.
+func markerContent(path, name string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(hash.Sum(nil)), name)), nil
+}
+
+type localDestination struct {
+	path   string
+	marker string
+}
+
+func (d *localDestination) String() string {
+	return d.path
+}
+
+func (d *localDestination) Deliver(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := writeFileAtomic(d.path, src); err != nil {
+		return err
+	}
+	if d.marker == "" {
+		return nil
+	}
+	content, err := markerContent(path, filepath.Base(d.path))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.path+d.marker, bytes.NewReader(content))
+}
+
+// writeFileAtomic writes the content of r to a temporary file which replaces
+// path afterwards.
+func writeFileAtomic(path string, r io.Reader) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+type ftpDestination struct {
+	sftp     bool
+	addr     string
+	user     string
+	password string
+	path     string
+	marker   string
+}
+
+func (d *ftpDestination) String() string {
+	scheme := "ftp"
+	if d.sftp {
+		scheme = "sftp"
+	}
+	return fmt.Sprintf("%s://%s@%s%s", scheme, d.user, d.addr, d.path)
+}
+
+func (d *ftpDestination) upload(path string, r io.Reader) error {
+	if d.sftp {
+		return ftp.SFTPUpload(d.addr, d.user, d.password, path, r)
+	}
+	return ftp.Upload(d.addr, d.user, d.password, path, r)
+}
+
+func (d *ftpDestination) Deliver(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := d.upload(d.path, src); err != nil {
+		return err
+	}
+	if d.marker == "" {
+		return nil
+	}
+	content, err := markerContent(path, filepath.Base(d.path))
+	if err != nil {
+		return err
+	}
+	return d.upload(d.path+d.marker, bytes.NewReader(content))
+}
+
+// httpDestination uploads the file as request body. A HTTP upload can not be
+// renamed, so the server has to make sure not to expose partial uploads.
+type httpDestination struct {
+	url     string
+	method  string
+	headers map[string]string
+	marker  string
+}
+
+func (d *httpDestination) String() string {
+	return d.url
+}
+
+func (d *httpDestination) upload(url string, r io.Reader, size int64, contentType string) error {
+	req, err := http.NewRequest(d.method, url, r)
+	if err != nil {
+		return err
+	}
+	// not all servers accept chunked uploads
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range d.headers {
+		req.Header.Set(key, value)
+	}
+	client := &http.Client{Timeout: HTTP_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s", d.method, url, resp.Status)
+	}
+	return nil
+}
+
+func (d *httpDestination) Deliver(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if err := d.upload(d.url, src, fi.Size(), "text/csv"); err != nil {
+		return err
+	}
+	if d.marker == "" {
+		return nil
+	}
+	content, err := markerContent(path, d.url[strings.LastIndex(d.url, "/")+1:])
+	if err != nil {
+		return err
+	}
+	return d.upload(d.url+d.marker, bytes.NewReader(content), int64(len(content)), "text/plain")
+}
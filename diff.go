@@ -0,0 +1,323 @@
+package mip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tealeg/xlsx"
+)
+
+// states of a PriceChange
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// ReadExport reads the records of an export written with the encoding enc and
+// the format f.
+// The columns are identified by the header, the id column contains the
+// complete key of the record (prefix and id). Unknown columns are read as
+// attributes.
+func ReadExport(r io.Reader, enc string, f *CSVFormat) ([]*Record, error) {
+	if enc == "utf8" {
+		enc = ""
+	}
+	r, _, err := DecodeInput(r, enc)
+	if err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = f.Delimiter
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		// an export without records has no header
+		return nil, nil
+	} else if err != nil {
+		return nil, parseErrorf("failed to read header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"Id", "Einkaufspreis", "Verkaufspreis"} {
+		if _, ok := columns[name]; !ok {
+			return nil, parseErrorf("column %s missing", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	number := func(row []string, name string) (float64, error) {
+		value := field(row, name)
+		if value == "" {
+			return 0, nil
+		}
+		number, err := f.parseNumber(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s '%s'", name, value)
+		}
+		return number, nil
+	}
+	price := func(row []string, name string) (Money, error) {
+		value := field(row, name)
+		if value == "" {
+			return 0, nil
+		}
+		price, err := f.parsePrice(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s '%s'", name, value)
+		}
+		return price, nil
+	}
+
+	var records []*Record
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, parseErrorf("failed to read export: %w", err)
+		}
+		r := &Record{
+			Id:             field(row, "Id"),
+			Description:    field(row, "Beschreibung"),
+			Category:       field(row, "Kategorie"),
+			CategoryNumber: field(row, "Kategorie-Nummer"),
+		}
+		for name, dst := range map[string]*Money{
+			"Einkaufspreis": &r.PurchasePrice,
+			"Verkaufspreis": &r.SellingPrice,
+		} {
+			if *dst, err = price(row, name); err != nil {
+				return nil, parseErrorf("line %d: %w", line, err)
+			}
+		}
+		for name, dst := range map[string]*float64{
+			"Einkaufsfaktor": &r.PurchaseFactor,
+			"Verkaufsfaktor": &r.SellingFactor,
+		} {
+			if *dst, err = number(row, name); err != nil {
+				return nil, parseErrorf("line %d: %w", line, err)
+			}
+		}
+		// the other columns are price tiers and attributes
+		for i, title := range header {
+			if isDefaultColumn(title) || i >= len(row) {
+				continue
+			}
+			tierField, n, ok := tierByTitle(title)
+			if !ok {
+				r.SetAttr(attributeByTitle(title), row[i])
+				continue
+			}
+			if row[i] == "" {
+				continue
+			}
+			if err := readTierField(r.setTier(n), tierField, row[i], f); err != nil {
+				return nil, parseErrorf("line %d: invalid %s '%s'", line, title, row[i])
+			}
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func readTierField(t *PriceTier, field, value string, f *CSVFormat) error {
+	var err error
+	switch field {
+	case tierQuantity:
+		t.Quantity, err = strconv.Atoi(value)
+	case tierPurchasePrice:
+		t.PurchasePrice, err = f.parsePrice(value)
+	case tierSellingPrice:
+		t.SellingPrice, err = f.parsePrice(value)
+	}
+	return err
+}
+
+// PriceChange is an article which was added, removed or whose price changed
+// between two imports.
+type PriceChange struct {
+	Key         string
+	Description string
+	State       string
+	// the prices are zero if the article was added or removed respectively
+	OldPrice Money
+	NewPrice Money
+	// Delta is the absolute, Percent the relative change of the price.
+	// Percent is NaN if there is no old price.
+	Delta   Money
+	Percent float64
+}
+
+// DiffReport contains the differences between two imports.
+type DiffReport struct {
+	Changes []*PriceChange
+}
+
+// Diff compares the records old with the records new. The purchase price is
+// compared if selling is false, otherwise the selling price.
+func Diff(old, new []*Record, selling bool) *DiffReport {
+	price := func(r *Record) Money {
+		if selling {
+			return r.SellingPrice
+		}
+		return r.PurchasePrice
+	}
+	oldRecords := make(map[string]*Record, len(old))
+	for _, r := range old {
+		oldRecords[r.Key()] = r
+	}
+	report := &DiffReport{}
+	seen := make(map[string]bool, len(new))
+	for _, r := range new {
+		seen[r.Key()] = true
+		o, ok := oldRecords[r.Key()]
+		if !ok {
+			report.Changes = append(report.Changes, &PriceChange{
+				Key:         r.Key(),
+				Description: r.Description,
+				State:       Added,
+				NewPrice:    price(r),
+				Delta:       price(r),
+				Percent:     math.NaN(),
+			})
+			continue
+		}
+		// the export contains the prices rounded to centimes
+		centimes := Rounding(RoundHalfUp)
+		if centimes.Round(price(o)) == centimes.Round(price(r)) {
+			continue
+		}
+		report.Changes = append(report.Changes, &PriceChange{
+			Key:         r.Key(),
+			Description: r.Description,
+			State:       Changed,
+			OldPrice:    price(o),
+			NewPrice:    price(r),
+			Delta:       price(r) - price(o),
+			Percent:     percent(price(o), price(r)),
+		})
+	}
+	for _, o := range old {
+		if seen[o.Key()] {
+			continue
+		}
+		report.Changes = append(report.Changes, &PriceChange{
+			Key:         o.Key(),
+			Description: o.Description,
+			State:       Removed,
+			OldPrice:    price(o),
+			Delta:       -price(o),
+			Percent:     -100,
+		})
+	}
+	report.Sort("key", false)
+	return report
+}
+
+func percent(old, new Money) float64 {
+	if old == 0 {
+		return math.NaN()
+	}
+	return float64(new-old) / float64(old) * 100
+}
+
+// Count returns the number of changes in state.
+func (d *DiffReport) Count(state string) int {
+	n := 0
+	for _, c := range d.Changes {
+		if c.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+// Sort sorts the changes by key, description, state, delta or percent.
+func (d *DiffReport) Sort(by string, desc bool) error {
+	var less func(a, b *PriceChange) bool
+	switch by {
+	case "key":
+		less = func(a, b *PriceChange) bool { return a.Key < b.Key }
+	case "description":
+		less = func(a, b *PriceChange) bool { return strings.ToLower(a.Description) < strings.ToLower(b.Description) }
+	case "state":
+		less = func(a, b *PriceChange) bool { return a.State < b.State }
+	case "delta":
+		less = func(a, b *PriceChange) bool { return a.Delta < b.Delta }
+	case "percent":
+		less = func(a, b *PriceChange) bool { return a.Percent < b.Percent }
+	default:
+		return configErrorf("unknown sort order '%s'", by)
+	}
+	sort.SliceStable(d.Changes, func(i, j int) bool {
+		a, b := d.Changes[i], d.Changes[j]
+		// changes without a percentage come last
+		if by == "percent" && math.IsNaN(a.Percent) != math.IsNaN(b.Percent) {
+			return math.IsNaN(b.Percent)
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return nil
+}
+
+// FormatPercent formats the percentage of a PriceChange.
+func FormatPercent(p float64) string {
+	if math.IsNaN(p) {
+		return ""
+	}
+	return fmt.Sprintf("%+.1f%%", p)
+}
+
+// FormatDelta formats the absolute change of a PriceChange with sign.
+func FormatDelta(d Money) string {
+	if d < 0 {
+		return d.Format(2)
+	}
+	return "+" + d.Format(2)
+}
+
+// WriteXLSX writes the report as Excel file to path.
+func (d *DiffReport) WriteXLSX(path string) error {
+	file := xlsx.NewFile()
+	sheet, err := file.AddSheet("Changes")
+	if err != nil {
+		return outputErrorf("failed to create xlsx: %w", err)
+	}
+	row := sheet.AddRow()
+	for _, title := range []string{"Id", "Description", "State", "Old price", "New price", "Delta", "Delta %"} {
+		row.AddCell().SetString(title)
+	}
+	for _, c := range d.Changes {
+		row := sheet.AddRow()
+		row.AddCell().SetString(c.Key)
+		row.AddCell().SetString(c.Description)
+		row.AddCell().SetString(c.State)
+		for _, price := range []Money{c.OldPrice, c.NewPrice, c.Delta} {
+			row.AddCell().SetFloatWithFormat(price.Float64(), "0.00")
+		}
+		if math.IsNaN(c.Percent) {
+			row.AddCell()
+		} else {
+			row.AddCell().SetFloatWithFormat(c.Percent/100, "0.0%")
+		}
+	}
+	if err := file.Save(path); err != nil {
+		return outputErrorf("failed to write xlsx: %w", err)
+	}
+	return nil
+}
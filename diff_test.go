@@ -0,0 +1,220 @@
+package mip
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadExport(t *testing.T) {
+	format := *DefaultCSVFormat
+	format.Delimiter = ';'
+	format.DecimalSeparator = ","
+	format.QuoteAll = false
+	columns, err := ExportColumns([]string{"id", "description", "category", "purchase_price", "purchase_factor",
+		"selling_price", "selling_factor", "category_number", "ean", "price_tiers_2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []*Record{
+		{
+			Id: "1", IdPrefix: "A-", Description: "Kabel, 2m \"lang\" Größe", Category: "Kabel", CategoryNumber: "42",
+			PurchasePrice: 123456, PurchaseFactor: 1.25, SellingPrice: 154321, SellingFactor: 1.5,
+			Attributes: Attributes{AttrEAN: "07612345678900"},
+			PriceTiers: []PriceTier{{10, 100000, 120000}, {50, 90049, 110050}},
+		},
+		{Id: "2", IdPrefix: "A-", Description: "Maus", PriceTiers: []PriceTier{{5, 10000, 20000}}},
+		{Id: "3", IdPrefix: "A-", Description: "Tastatur; kabellos", PurchasePrice: -5000},
+	}
+	// the prices are rounded to centimes, the delimiter is replaced and
+	// the id contains the prefix
+	want := []*Record{
+		{
+			Id: "A-1", Description: "Kabel, 2m \"lang\" Größe", Category: "Kabel", CategoryNumber: "42",
+			PurchasePrice: 123500, PurchaseFactor: 1.25, SellingPrice: 154300, SellingFactor: 1.5,
+			Attributes: Attributes{AttrEAN: "07612345678900"},
+			PriceTiers: []PriceTier{{10, 100000, 120000}, {50, 90000, 110100}},
+		},
+		{Id: "A-2", Description: "Maus", PriceTiers: []PriceTier{{5, 10000, 20000}}},
+		{Id: "A-3", Description: "Tastatur  kabellos", PurchasePrice: -5000},
+	}
+
+	for _, enc := range []string{"utf8", "iso-8859-1"} {
+		var buf bytes.Buffer
+		export, err := NewExport(&buf, enc, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		export.SetFormat(&format)
+		export.SetColumns(columns)
+		for _, r := range records {
+			if err := export.WriteRecord(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := export.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ReadExport(&buf, enc, &format)
+		if err != nil {
+			t.Fatalf("%s: %s", enc, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d records read, want %d", enc, len(got), len(want))
+		}
+		for i := range want {
+			if !reflect.DeepEqual(got[i], want[i]) {
+				t.Errorf("%s: record %d = %+v, want %+v", enc, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestReadExportErrors(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content string
+		records int
+		err     bool
+	}{
+		{"empty", "", 0, false},
+		{"header only", "Id,Einkaufspreis,Verkaufspreis\n", 0, false},
+		{"missing column", "Id,Einkaufspreis\nA-1,1.00\n", 0, true},
+		{"invalid price", "Id,Einkaufspreis,Verkaufspreis\nA-1,abc,1.00\n", 0, true},
+		{"invalid factor", "Id,Einkaufspreis,Verkaufspreis,Einkaufsfaktor\nA-1,1.00,1.00,x\n", 0, true},
+		{"invalid tier", "Id,Einkaufspreis,Verkaufspreis,Staffelmenge 1\nA-1,1.00,1.00,viele\n", 0, true},
+		{"invalid csv", "Id,Einkaufspreis,Verkaufspreis\n\"A-1,1.00,1.00\n", 0, true},
+		{"short row", "Id,Einkaufspreis,Verkaufspreis\nA-1\n", 1, false},
+	} {
+		records, err := ReadExport(strings.NewReader(test.content), "utf8", DefaultCSVFormat)
+		if test.err != (err != nil) {
+			t.Errorf("%s: ReadExport returned %v", test.name, err)
+		} else if err != nil && KindOf(err) != KindParse {
+			t.Errorf("%s: ReadExport returned %v, want a parse error", test.name, err)
+		}
+		if len(records) != test.records {
+			t.Errorf("%s: %d records read, want %d", test.name, len(records), test.records)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := []*Record{
+		{Id: "1", Description: "same", PurchasePrice: 100000, SellingPrice: 200000},
+		{Id: "2", Description: "rounded", PurchasePrice: 100049, SellingPrice: 200000},
+		{Id: "3", Description: "cheaper", PurchasePrice: 100000, SellingPrice: 200000},
+		{Id: "4", Description: "removed", PurchasePrice: 50000, SellingPrice: 60000},
+		{Id: "5", Description: "from zero", PurchasePrice: 0, SellingPrice: 200000},
+	}
+	new := []*Record{
+		{Id: "1", Description: "same", PurchasePrice: 100000, SellingPrice: 200000},
+		// 10.0049 and 9.9951 are both 10.00 in the export
+		{Id: "2", Description: "rounded", PurchasePrice: 99951, SellingPrice: 200000},
+		{Id: "3", Description: "cheaper", PurchasePrice: 75000, SellingPrice: 300000},
+		{Id: "5", Description: "from zero", PurchasePrice: 10000, SellingPrice: 200000},
+		{Id: "6", Description: "added", PurchasePrice: 20000, SellingPrice: 30000},
+	}
+
+	for _, test := range []struct {
+		selling bool
+		want    []PriceChange
+	}{
+		{false, []PriceChange{
+			{"3", "cheaper", Changed, 100000, 75000, -25000, -25},
+			{"4", "removed", Removed, 50000, 0, -50000, -100},
+			{"5", "from zero", Changed, 0, 10000, 10000, math.NaN()},
+			{"6", "added", Added, 0, 20000, 20000, math.NaN()},
+		}},
+		{true, []PriceChange{
+			{"3", "cheaper", Changed, 200000, 300000, 100000, 50},
+			{"4", "removed", Removed, 60000, 0, -60000, -100},
+			{"6", "added", Added, 0, 30000, 30000, math.NaN()},
+		}},
+	} {
+		report := Diff(old, new, test.selling)
+		if len(report.Changes) != len(test.want) {
+			t.Errorf("selling %t: %d changes, want %d", test.selling, len(report.Changes), len(test.want))
+			continue
+		}
+		for i, want := range test.want {
+			got := *report.Changes[i]
+			samePercent := got.Percent == want.Percent || math.IsNaN(got.Percent) && math.IsNaN(want.Percent)
+			got.Percent, want.Percent = 0, 0
+			if got != want || !samePercent {
+				t.Errorf("selling %t: change %d = %+v, want %+v", test.selling, i, *report.Changes[i], test.want[i])
+			}
+		}
+	}
+
+	report := Diff(old, new, false)
+	for state, want := range map[string]int{Added: 1, Removed: 1, Changed: 2} {
+		if got := report.Count(state); got != want {
+			t.Errorf("Count(%s) = %d, want %d", state, got, want)
+		}
+	}
+}
+
+func TestDiffSort(t *testing.T) {
+	changes := func() *DiffReport {
+		return &DiffReport{Changes: []*PriceChange{
+			{Key: "a", Description: "b", State: Added, Delta: 30000, Percent: math.NaN()},
+			{Key: "b", Description: "C", State: Changed, Delta: -10000, Percent: -10},
+			{Key: "c", Description: "a", State: Removed, Delta: -50000, Percent: -100},
+			{Key: "d", Description: "d", State: Changed, Delta: 20000, Percent: math.NaN()},
+			{Key: "e", Description: "e", State: Changed, Delta: 5000, Percent: 25},
+		}}
+	}
+	for _, test := range []struct {
+		by   string
+		desc bool
+		want string
+	}{
+		{"key", false, "abcde"},
+		{"key", true, "edcba"},
+		{"description", false, "cabde"},
+		{"state", false, "abdec"},
+		{"delta", false, "cbeda"},
+		{"delta", true, "adebc"},
+		// changes without percentage come last in both orders
+		{"percent", false, "cbead"},
+		{"percent", true, "ebcad"},
+	} {
+		report := changes()
+		if err := report.Sort(test.by, test.desc); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for _, c := range report.Changes {
+			got += c.Key
+		}
+		if got != test.want {
+			t.Errorf("Sort(%s, %t) = %s, want %s", test.by, test.desc, got, test.want)
+		}
+	}
+	if err := changes().Sort("price", false); err == nil {
+		t.Error("Sort(\"price\") returned no error")
+	} else if KindOf(err) != KindConfig {
+		t.Errorf("Sort(\"price\") returned %v, want a config error", err)
+	}
+}
+
+func TestFormatDiff(t *testing.T) {
+	for _, test := range []struct {
+		delta   Money
+		percent float64
+		want    string
+	}{
+		{12345, 12.34, "+1.23 +12.3%"},
+		{-12345, -12.36, "-1.23 -12.4%"},
+		{0, 0, "+0.00 +0.0%"},
+		{10000, math.NaN(), "+1.00 "},
+	} {
+		if got := FormatDelta(test.delta) + " " + FormatPercent(test.percent); got != test.want {
+			t.Errorf("FormatDelta(%d), FormatPercent(%g) = %q, want %q", test.delta, test.percent, got, test.want)
+		}
+	}
+}
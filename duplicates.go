@@ -0,0 +1,234 @@
+package mip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// modes to select the offer of a duplicate product
+const (
+	SelectAll       = "all"
+	SelectCheapest  = "cheapest"
+	SelectPreferred = "preferred"
+)
+
+// DuplicateGroup is a product which is offered by more than one supplier.
+type DuplicateGroup struct {
+	// MatchedBy is the attribute by which the records were matched (ean or
+	// mpn)
+	MatchedBy string
+	Records   []*Record
+	// Keep is the offer selected by SelectOffers
+	Keep *Record
+}
+
+// normalizeEAN removes leading zeros, so an UPC-A (12 digits) matches the
+// same product as EAN-13.
+func normalizeEAN(ean string) string {
+	ean = strings.TrimLeft(strings.TrimSpace(ean), "0")
+	for _, c := range ean {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	return ean
+}
+
+// normalizeMPN removes the separators which are used inconsistently by the
+// suppliers. As different manufacturers can use the same part numbers, the
+// manufacturer is part of the key.
+func normalizeMPN(manufacturer, mpn string) string {
+	mpn = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", ".", "", "/", "").Replace(mpn))
+	if mpn == "" {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSpace(manufacturer)) + "/" + mpn
+}
+
+// FindDuplicates returns the products which are offered by more than one
+// supplier. The records are matched by the attributes in match (ean and/or
+// mpn). Records which match by any of the attributes belong to the same
+// product.
+func FindDuplicates(records []*Record, match []string) ([]*DuplicateGroup, error) {
+	// union-find over the indices of records
+	parent := make([]int, len(records))
+	for n := range parent {
+		parent[n] = n
+	}
+	var find func(int) int
+	find = func(n int) int {
+		if parent[n] != n {
+			parent[n] = find(parent[n])
+		}
+		return parent[n]
+	}
+	matchedBy := map[int]string{}
+
+	for _, attr := range match {
+		var key func(r *Record) string
+		switch attr {
+		case "ean":
+			key = func(r *Record) string { return normalizeEAN(r.Attr(AttrEAN)) }
+		case "mpn":
+			key = func(r *Record) string { return normalizeMPN(r.Attr(AttrManufacturer), r.Attr(AttrMPN)) }
+		default:
+			return nil, configErrorf("unknown duplicate match '%s'", attr)
+		}
+		first := map[string]int{}
+		for n, r := range records {
+			k := key(r)
+			if k == "" {
+				continue
+			}
+			other, ok := first[k]
+			if !ok {
+				first[k] = n
+				continue
+			}
+			a, b := find(other), find(n)
+			if a != b {
+				parent[b] = a
+				if _, ok := matchedBy[a]; !ok {
+					matchedBy[a] = attr
+				}
+			}
+		}
+	}
+
+	byRoot := map[int]*DuplicateGroup{}
+	var groups []*DuplicateGroup
+	for n, r := range records {
+		root := find(n)
+		g, ok := byRoot[root]
+		if !ok {
+			g = &DuplicateGroup{MatchedBy: matchedBy[root]}
+			byRoot[root] = g
+			groups = append(groups, g)
+		}
+		g.Records = append(g.Records, r)
+	}
+
+	// only products offered by different suppliers are duplicates
+	var duplicates []*DuplicateGroup
+	for _, g := range groups {
+		suppliers := map[string]bool{}
+		for _, r := range g.Records {
+			suppliers[r.Supplier] = true
+		}
+		if len(suppliers) > 1 {
+			duplicates = append(duplicates, g)
+		}
+	}
+	return duplicates, nil
+}
+
+// SelectOffers selects the offer to keep of each group. With SelectCheapest
+// the offer with the lowest purchase price is kept, with SelectPreferred the
+// offer of the first supplier in preferred. If none of the suppliers is
+// preferred, the cheapest offer is kept. With SelectAll all offers are kept.
+func SelectOffers(groups []*DuplicateGroup, mode string, preferred []string) error {
+	rank := map[string]int{}
+	for n, supplier := range preferred {
+		rank[strings.ToLower(supplier)] = n + 1
+	}
+	for _, g := range groups {
+		var less func(a, b *Record) bool
+		cheaper := func(a, b *Record) bool {
+			if a.PurchasePrice != b.PurchasePrice {
+				return a.PurchasePrice < b.PurchasePrice
+			}
+			return a.Key() < b.Key()
+		}
+		switch mode {
+		case SelectAll:
+			g.Keep = nil
+			continue
+		case SelectCheapest:
+			less = cheaper
+		case SelectPreferred:
+			less = func(a, b *Record) bool {
+				ra, rb := rank[strings.ToLower(a.Supplier)], rank[strings.ToLower(b.Supplier)]
+				if ra != rb {
+					// suppliers which are not preferred have rank 0
+					return rb == 0 || (ra != 0 && ra < rb)
+				}
+				return cheaper(a, b)
+			}
+		default:
+			return configErrorf("unknown duplicate selection '%s'", mode)
+		}
+		candidates := make([]*Record, 0, len(g.Records))
+		for _, r := range g.Records {
+			// an offer without price is not an offer
+			if r.PurchasePrice > 0 {
+				candidates = append(candidates, r)
+			}
+		}
+		if len(candidates) == 0 {
+			// sorting must not change the order of the report
+			candidates = append(candidates, g.Records...)
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return less(candidates[i], candidates[j]) })
+		g.Keep = candidates[0]
+	}
+	return nil
+}
+
+// Drop returns a set of the keys of the records which are not kept.
+func Drop(groups []*DuplicateGroup) map[string]bool {
+	drop := map[string]bool{}
+	for _, g := range groups {
+		if g.Keep == nil {
+			continue
+		}
+		for _, r := range g.Records {
+			if r != g.Keep {
+				drop[r.Key()] = true
+			}
+		}
+	}
+	return drop
+}
+
+// WriteDuplicates writes the duplicate groups as CSV to path. Each offer is
+// one line, the offers of the same product have the same group number.
+func WriteDuplicates(path string, groups []*DuplicateGroup) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return outputErrorf("failed to write duplicates: %w", err)
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"group", "matched_by", "supplier", "id", "manufacturer", "mpn", "ean", "description", "purchase_price", "kept"})
+	for n, g := range groups {
+		for _, r := range g.Records {
+			kept := "yes"
+			if g.Keep != nil && g.Keep != r {
+				kept = "no"
+			}
+			w.Write([]string{
+				fmt.Sprint(n + 1),
+				g.MatchedBy,
+				r.Supplier,
+				r.Key(),
+				r.Attr(AttrManufacturer),
+				r.Attr(AttrMPN),
+				r.Attr(AttrEAN),
+				r.Description,
+				r.PurchasePrice.Format(2),
+				kept,
+			})
+		}
+	}
+	w.Flush()
+	err = w.Error()
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return outputErrorf("failed to write duplicates: %w", err)
+	}
+	return nil
+}
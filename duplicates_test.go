@@ -0,0 +1,163 @@
+package mip
+
+import (
+	"strings"
+	"testing"
+)
+
+// offer returns a record of supplier with the key <supplier>-<id>.
+func offer(supplier, id string, price Money, attrs ...string) *Record {
+	r := &Record{Supplier: supplier, IdPrefix: supplier + "-", Id: id, PurchasePrice: price}
+	for i := 0; i < len(attrs); i += 2 {
+		r.SetAttr(attrs[i], attrs[i+1])
+	}
+	return r
+}
+
+// groupKeys returns the keys of the records of each group.
+func groupKeys(groups []*DuplicateGroup) []string {
+	var keys []string
+	for _, g := range groups {
+		var group []string
+		for _, r := range g.Records {
+			group = append(group, r.Key())
+		}
+		keys = append(keys, g.MatchedBy+": "+strings.Join(group, " "))
+	}
+	return keys
+}
+
+func TestNormalizeEAN(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"7612345678900", "7612345678900"},
+		{"07612345678900", "7612345678900"},
+		{" 0761234567890 ", "761234567890"},
+		{"000", ""},
+		{"", ""},
+		{"761-234", ""},
+		{"n/a", ""},
+	} {
+		if got := normalizeEAN(test.in); got != test.want {
+			t.Errorf("normalizeEAN(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeMPN(t *testing.T) {
+	for _, test := range []struct {
+		manufacturer, mpn, want string
+	}{
+		{"Logitech", "910-005.565/a", "LOGITECH/910005565A"},
+		{" logitech ", "910 005565A", "LOGITECH/910005565A"},
+		{"", "X1", "/X1"},
+		{"HP", " - ", ""},
+		{"HP", "", ""},
+	} {
+		if got := normalizeMPN(test.manufacturer, test.mpn); got != test.want {
+			t.Errorf("normalizeMPN(%q, %q) = %q, want %q", test.manufacturer, test.mpn, got, test.want)
+		}
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	records := []*Record{
+		offer("a", "1", 0, AttrEAN, "07612345678900", AttrManufacturer, "Logitech", AttrMPN, "K-120"),
+		offer("b", "1", 0, AttrEAN, " 7612345678900"),
+		// matches b-1 only through a-1
+		offer("c", "1", 0, AttrManufacturer, "LOGITECH", AttrMPN, "k120"),
+		// offered by one supplier only
+		offer("a", "2", 0, AttrEAN, "4000000000001"),
+		offer("a", "3", 0, AttrEAN, "4000000000001"),
+		// the same part number of different manufacturers
+		offer("b", "2", 0, AttrManufacturer, "HP", AttrMPN, "X1"),
+		offer("c", "2", 0, AttrManufacturer, "Canon", AttrMPN, "X1"),
+		// invalid EANs
+		offer("b", "3", 0, AttrEAN, "n/a"),
+		offer("c", "3", 0, AttrEAN, "n/a"),
+		// a chain of EAN, MPN and EAN matches
+		offer("a", "4", 0, AttrEAN, "555"),
+		offer("b", "4", 0, AttrEAN, "555", AttrManufacturer, "M", AttrMPN, "Z"),
+		offer("c", "4", 0, AttrEAN, "666", AttrManufacturer, "M", AttrMPN, "Z"),
+		offer("a", "5", 0, AttrEAN, "0666"),
+	}
+	for _, test := range []struct {
+		match []string
+		want  []string
+	}{
+		{[]string{"ean", "mpn"}, []string{"ean: a-1 b-1 c-1", "ean: a-4 b-4 c-4 a-5"}},
+		{[]string{"mpn", "ean"}, []string{"mpn: a-1 b-1 c-1", "ean: a-4 b-4 c-4 a-5"}},
+		{[]string{"ean"}, []string{"ean: a-1 b-1", "ean: a-4 b-4", "ean: c-4 a-5"}},
+		{[]string{"mpn"}, []string{"mpn: a-1 c-1", "mpn: b-4 c-4"}},
+		{nil, nil},
+	} {
+		groups, err := FindDuplicates(records, test.match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := groupKeys(groups)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("FindDuplicates(%v) = %q, want %q", test.match, got, test.want)
+		}
+	}
+
+	if _, err := FindDuplicates(records, []string{"description"}); err == nil {
+		t.Error("FindDuplicates(description) returned no error")
+	} else if KindOf(err) != KindConfig {
+		t.Errorf("FindDuplicates(description) returned %v, want a config error", err)
+	}
+}
+
+func TestSelectOffers(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		mode      string
+		preferred []string
+		records   []*Record
+		want      string
+	}{
+		{"cheapest", SelectCheapest, nil, []*Record{offer("a", "1", 100000), offer("b", "1", 80000), offer("c", "1", 90000)}, "b-1"},
+		{"cheapest tie", SelectCheapest, nil, []*Record{offer("b", "1", 80000), offer("a", "1", 80000)}, "a-1"},
+		{"cheapest without price", SelectCheapest, nil, []*Record{offer("a", "1", 0), offer("b", "1", 80000)}, "b-1"},
+		{"all without price", SelectCheapest, nil, []*Record{offer("b", "1", 0), offer("a", "1", 0)}, "a-1"},
+		{"preferred", SelectPreferred, []string{"C", "B"}, []*Record{offer("a", "1", 50000), offer("b", "1", 80000), offer("c", "1", 90000)}, "c-1"},
+		{"second preferred", SelectPreferred, []string{"x", "B"}, []*Record{offer("a", "1", 50000), offer("b", "1", 80000), offer("c", "1", 90000)}, "b-1"},
+		{"no preferred in group", SelectPreferred, []string{"x"}, []*Record{offer("b", "1", 80000), offer("a", "1", 50000)}, "a-1"},
+		{"no preferred", SelectPreferred, nil, []*Record{offer("b", "1", 80000), offer("a", "1", 80000)}, "a-1"},
+		{"preferred without price", SelectPreferred, []string{"c"}, []*Record{offer("c", "1", 0), offer("b", "1", 80000)}, "b-1"},
+		{"preferred tie", SelectPreferred, []string{"a"}, []*Record{offer("a", "2", 80000), offer("a", "1", 90000), offer("b", "1", 10000)}, "a-2"},
+		{"all", SelectAll, nil, []*Record{offer("a", "1", 100000), offer("b", "1", 80000)}, ""},
+	} {
+		g := &DuplicateGroup{MatchedBy: "ean", Records: append([]*Record{}, test.records...)}
+		if err := SelectOffers([]*DuplicateGroup{g}, test.mode, test.preferred); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if g.Keep != nil {
+			got = g.Keep.Key()
+		}
+		if got != test.want {
+			t.Errorf("%s: kept %q, want %q", test.name, got, test.want)
+		}
+		// the order of the records is not changed
+		for i, r := range g.Records {
+			if r != test.records[i] {
+				t.Errorf("%s: records reordered", test.name)
+				break
+			}
+		}
+		drop := Drop([]*DuplicateGroup{g})
+		for _, r := range g.Records {
+			if drop[r.Key()] != (g.Keep != nil && r != g.Keep) {
+				t.Errorf("%s: Drop()[%s] = %t", test.name, r.Key(), drop[r.Key()])
+			}
+		}
+	}
+
+	if err := SelectOffers([]*DuplicateGroup{{}}, "newest", nil); err == nil {
+		t.Error("SelectOffers(newest) returned no error")
+	} else if KindOf(err) != KindConfig {
+		t.Errorf("SelectOffers(newest) returned %v, want a config error", err)
+	}
+}
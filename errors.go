@@ -0,0 +1,93 @@
+package mip
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies the errors which can occur during an import.
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	// KindConfig indicates a missing or invalid configuration
+	KindConfig
+	// KindSource indicates that an input could not be opened or downloaded
+	KindSource
+	// KindParse indicates that an input could be read but not understood
+	KindParse
+	// KindOutput indicates that the export could not be written
+	KindOutput
+	// KindGuard indicates that the result of an import failed a sanity check
+	KindGuard
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindConfig:
+		return "config error"
+	case KindSource:
+		return "source error"
+	case KindParse:
+		return "parse error"
+	case KindOutput:
+		return "output error"
+	case KindGuard:
+		return "sanity check failed"
+	}
+	return "error"
+}
+
+// Error is an error of a certain kind. The underlying error is available
+// through errors.Unwrap.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of the first Error in the chain of err or
+// KindUnknown if there is none.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindUnknown
+}
+
+// WrapError returns err as an Error of the given kind. If err already has a
+// kind, it is returned unchanged.
+func WrapError(kind ErrorKind, err error) error {
+	if err == nil || KindOf(err) != KindUnknown {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+func configErrorf(format string, a ...interface{}) error {
+	return &Error{Kind: KindConfig, Err: fmt.Errorf(format, a...)}
+}
+
+func sourceErrorf(format string, a ...interface{}) error {
+	return &Error{Kind: KindSource, Err: fmt.Errorf(format, a...)}
+}
+
+func parseErrorf(format string, a ...interface{}) error {
+	return &Error{Kind: KindParse, Err: fmt.Errorf(format, a...)}
+}
+
+func outputErrorf(format string, a ...interface{}) error {
+	return &Error{Kind: KindOutput, Err: fmt.Errorf(format, a...)}
+}
+
+func guardErrorf(format string, a ...interface{}) error {
+	return &Error{Kind: KindGuard, Err: fmt.Errorf(format, a...)}
+}
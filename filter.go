@@ -0,0 +1,262 @@
+package mip
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// reason of the records which match none of the include rules
+const notIncluded = "not included"
+
+// filterRule is a comparison of a field of a record with a value.
+type filterRule struct {
+	expr   string
+	reason string
+	field  string
+	match  func(r *Record) bool
+}
+
+// Filter decides which records of an import are ignored. A record is ignored
+// if there are include rules and it matches none of them, or if it matches
+// one of the exclude rules. The rules are read from cfg:
+//
+//	include   list of rules, a record has to match one of them
+//	exclude   list of rules, a record must not match any of them
+//
+// A rule is an expression or a map with the expression (rule) and the reason
+// under which the ignored records are counted (reason). An expression
+// compares a field with a value:
+//
+//	field == value      equal
+//	field != value      not equal
+//	field ~= value      equal ignoring case
+//	field ~ /regex/     matches the regular expression (/regex/i ignores
+//	field !~ /regex/    the case) or does not match it
+//	field < number      numeric comparisons, also <=, > and >=
+//
+// The fields are id, key, description, category, category_number,
+// purchase_price, purchase_factor, selling_price, selling_factor and the
+// attributes (e.g. manufacturer or supplier_category). The filter is applied
+// by the importers before the category mapping. Values can be quoted with
+// double quotes.
+type Filter struct {
+	include []*filterRule
+	exclude []*filterRule
+}
+
+// NewFilter returns the filter configured in cfg. cfg may be nil.
+func NewFilter(cfg *viper.Viper) (*Filter, error) {
+	f := &Filter{}
+	if cfg == nil {
+		return f, nil
+	}
+	for _, list := range []struct {
+		key   string
+		rules *[]*filterRule
+	}{
+		{"include", &f.include},
+		{"exclude", &f.exclude},
+	} {
+		for n, entry := range cast.ToSlice(cfg.Get(list.key)) {
+			var expr, reason string
+			if m, ok := entry.(string); ok {
+				expr = m
+			} else {
+				m := cast.ToStringMapString(entry)
+				expr, reason = m["rule"], m["reason"]
+			}
+			rule, err := parseFilterRule(expr)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", list.key, n, err)
+			}
+			if reason != "" {
+				rule.reason = reason
+			}
+			*list.rules = append(*list.rules, rule)
+		}
+	}
+	return f, nil
+}
+
+var filterExpr = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*(==|!=|~=|!~|~|<=|>=|<|>)\s*(.*?)\s*$`)
+
+func parseFilterRule(expr string) (*filterRule, error) {
+	m := filterExpr.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, configErrorf("invalid rule '%s'", expr)
+	}
+	field, op, value := m[1], m[2], m[3]
+	if strings.HasPrefix(value, "\"") {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, configErrorf("invalid value in rule '%s': %w", expr, err)
+		}
+		value = unquoted
+	}
+	rule := &filterRule{
+		expr:   expr,
+		reason: "excluded by '" + strings.TrimSpace(expr) + "'",
+		field:  field,
+	}
+	get := fieldGetter(field)
+
+	switch op {
+	case "==":
+		rule.match = func(r *Record) bool { return equalValue(get(r), value) }
+	case "!=":
+		rule.match = func(r *Record) bool { return !equalValue(get(r), value) }
+	case "~=":
+		rule.match = func(r *Record) bool { return strings.EqualFold(get(r), value) }
+	case "~", "!~":
+		re, err := parseRegex(value)
+		if err != nil {
+			return nil, configErrorf("invalid regex in rule '%s': %w", expr, err)
+		}
+		negate := op == "!~"
+		rule.match = func(r *Record) bool { return re.MatchString(get(r)) != negate }
+	default:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, configErrorf("rule '%s' needs a number", expr)
+		}
+		compare := map[string]func(a float64) bool{
+			"<":  func(a float64) bool { return a < number },
+			"<=": func(a float64) bool { return a <= number },
+			">":  func(a float64) bool { return a > number },
+			">=": func(a float64) bool { return a >= number },
+		}[op]
+		rule.match = func(r *Record) bool {
+			// fields which are no number never match
+			a, err := strconv.ParseFloat(get(r), 64)
+			return err == nil && compare(a)
+		}
+	}
+	return rule, nil
+}
+
+// parseRegex parses a regular expression in the form /regex/ or /regex/i.
+func parseRegex(value string) (*regexp.Regexp, error) {
+	end := strings.LastIndex(value, "/")
+	if !strings.HasPrefix(value, "/") || end < 1 {
+		return nil, fmt.Errorf("expected /regex/")
+	}
+	pattern, flags := value[1:end], value[end+1:]
+	switch flags {
+	case "":
+	case "i":
+		pattern = "(?i)" + pattern
+	default:
+		return nil, fmt.Errorf("unknown flags '%s'", flags)
+	}
+	return regexp.Compile(pattern)
+}
+
+// equalValue compares numbers numerically, so "1.0" equals "1".
+func equalValue(a, b string) bool {
+	if a == b {
+		return true
+	}
+	x, err1 := strconv.ParseFloat(a, 64)
+	y, err2 := strconv.ParseFloat(b, 64)
+	return err1 == nil && err2 == nil && x == y
+}
+
+// priceFields are the fields which are only known once the price of an
+// article is known.
+var priceFields = map[string]bool{
+	"purchase_price":  true,
+	"purchase_factor": true,
+	"selling_price":   true,
+	"selling_factor":  true,
+}
+
+func fieldGetter(field string) func(r *Record) string {
+	number := func(f func(r *Record) float64) func(r *Record) string {
+		return func(r *Record) string { return strconv.FormatFloat(f(r), 'f', -1, 64) }
+	}
+	switch field {
+	case "id":
+		return func(r *Record) string { return r.Id }
+	case "key":
+		return func(r *Record) string { return r.Key() }
+	case "description":
+		return func(r *Record) string { return r.Description }
+	case "category":
+		return func(r *Record) string { return r.Category }
+	case "category_number":
+		return func(r *Record) string { return r.CategoryNumber }
+	case "purchase_price":
+		return number(func(r *Record) float64 { return r.PurchasePrice.Float64() })
+	case "purchase_factor":
+		return number(func(r *Record) float64 { return r.PurchaseFactor })
+	case "selling_price":
+		return number(func(r *Record) float64 { return r.SellingPrice.Float64() })
+	case "selling_factor":
+		return number(func(r *Record) float64 { return r.SellingFactor })
+	}
+	return func(r *Record) string { return r.Attr(field) }
+}
+
+// ExcludeValues adds a rule which excludes the records whose field equals
+// one of values. The records are counted as ignored with reason. It is used
+// for the lists of ignored values of the importers.
+func (f *Filter) ExcludeValues(field string, values []string, reason string) {
+	if len(values) == 0 {
+		return
+	}
+	get := fieldGetter(field)
+	f.exclude = append(f.exclude, &filterRule{
+		expr:   fmt.Sprintf("%s in %q", field, values),
+		reason: reason,
+		field:  field,
+		match: func(r *Record) bool {
+			value := get(r)
+			for _, v := range values {
+				if value == v {
+					return true
+				}
+			}
+			return false
+		},
+	})
+}
+
+// Ignore returns true and the reason if r has to be ignored.
+func (f *Filter) Ignore(r *Record) (string, bool) {
+	return f.ignore(r, false)
+}
+
+// IgnoreUnpriced is like Ignore for a record whose price is not known. The
+// rules on the prices and factors are not evaluated.
+func (f *Filter) IgnoreUnpriced(r *Record) (string, bool) {
+	return f.ignore(r, true)
+}
+
+func (f *Filter) ignore(r *Record, unpriced bool) (string, bool) {
+	if len(f.include) > 0 {
+		included := false
+		for _, rule := range f.include {
+			if (unpriced && priceFields[rule.field]) || rule.match(r) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return notIncluded, true
+		}
+	}
+	for _, rule := range f.exclude {
+		if unpriced && priceFields[rule.field] {
+			continue
+		}
+		if rule.match(r) {
+			return rule.reason, true
+		}
+	}
+	return "", false
+}
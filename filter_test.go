@@ -0,0 +1,80 @@
+package mip
+
+import "testing"
+
+func TestParseFilterRule(t *testing.T) {
+	r := &Record{
+		Id:            "100",
+		IdPrefix:      "A-",
+		Description:   "Kabel, 2m",
+		PurchasePrice: 95000,
+		Category:      "Alltron",
+	}
+	r.SetAttr(AttrManufacturer, "HP")
+	r.SetAttr(AttrEAN, "0012345678905")
+
+	for _, test := range []struct {
+		expr  string
+		match bool
+	}{
+		{"id == 100", true},
+		{"id == 100.0", true},
+		{"id != 100", false},
+		{"key == A-100", true},
+		{`description == "Kabel, 2m"`, true},
+		{`description == "kabel, 2m"`, false},
+		{`description ~= "kabel, 2m"`, true},
+		{"description ~ /^Kabel/", true},
+		{"description ~ /^kabel/", false},
+		{"description ~ /^kabel/i", true},
+		{"description !~ /^Maus/", true},
+		{"manufacturer == HP", true},
+		{"manufacturer==HP", true},
+		{"  manufacturer  ==  HP  ", true},
+		{"ean == 12345678905", true},
+		{"mpn == ''", false},
+		{`mpn == ""`, true},
+		{"purchase_price < 10", true},
+		{"purchase_price <= 9.5", true},
+		{"purchase_price > 9.5", false},
+		{"purchase_price >= 9.5", true},
+		{"description > 1", false},
+	} {
+		rule, err := parseFilterRule(test.expr)
+		if err != nil {
+			t.Errorf("parseFilterRule(%q): %s", test.expr, err)
+			continue
+		}
+		if got := rule.match(r); got != test.match {
+			t.Errorf("rule %q matches %t, want %t", test.expr, got, test.match)
+		}
+	}
+}
+
+func TestParseFilterRuleErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"manufacturer",
+		"manufacturer = HP",
+		"1id == 100",
+		`description == "unterminated`,
+		"description ~ Kabel",
+		"description ~ /Kabel/x",
+		"description ~ /(/",
+		"purchase_price < ten",
+	} {
+		if _, err := parseFilterRule(expr); err == nil {
+			t.Errorf("parseFilterRule(%q) returned no error", expr)
+		}
+	}
+}
+
+func TestParseFilterRuleReason(t *testing.T) {
+	rule, err := parseFilterRule(" manufacturer == HP ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "excluded by 'manufacturer == HP'"; rule.reason != want {
+		t.Errorf("reason = %q, want %q", rule.reason, want)
+	}
+}
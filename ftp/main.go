@@ -2,6 +2,7 @@ package ftp
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
@@ -35,15 +36,25 @@ func (r *Response) Close() error {
 	return nil
 }
 
-func Open(addr, user, password, path string) (io.ReadCloser, int64, error) {
+func dial(addr, user, password string) (*ftp.ServerConn, error) {
 	conn, err := ftp.DialTimeout(addr, CONNECT_TIMEOUT)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
 	conn.DisableEPSV = true
 
 	err = conn.Login(user, password)
+	if err != nil {
+		conn.Quit()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func Open(addr, user, password, path string) (io.ReadCloser, int64, error) {
+	conn, err := dial(addr, user, password)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -62,7 +73,49 @@ func Open(addr, user, password, path string) (io.ReadCloser, int64, error) {
 
 }
 
-func SFTPOpen(addr, user, password, path string) (io.ReadCloser, int64, error) {
+// Upload stores the content of r as path on the FTP server. The content is
+// uploaded to a temporary file which is renamed to path afterwards, so
+// nobody reads a partially uploaded file.
+func Upload(addr, user, password, path string, r io.Reader) error {
+	conn, err := dial(addr, user, password)
+	if err != nil {
+		return err
+	}
+	defer conn.Quit()
+
+	tmp := path + ".tmp"
+	err = conn.Stor(tmp, r)
+	if err != nil {
+		return err
+	}
+
+	err = conn.Rename(tmp, path)
+	if err != nil && ftpExists(conn, path) {
+		// not all servers replace an existing file on rename. other errors
+		// (e.g. permissions) keep the existing file.
+		if err = conn.Delete(path); err == nil {
+			err = conn.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		// tmp is kept, so the upload is not lost if path was deleted
+		return fmt.Errorf("failed to rename %s to %s: %w", tmp, path, err)
+	}
+
+	return nil
+}
+
+// ftpExists returns true if the file path exists on the FTP server.
+func ftpExists(conn *ftp.ServerConn, path string) bool {
+	if _, err := conn.FileSize(path); err == nil {
+		return true
+	}
+	// not all servers support SIZE
+	entries, err := conn.NameList(path)
+	return err == nil && len(entries) > 0
+}
+
+func sftpDial(addr, user, password string) (*ssh.Client, *sftp.Client, error) {
 	sshConfig := &ssh.ClientConfig{
 		User: user,
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
@@ -76,10 +129,20 @@ func SFTPOpen(addr, user, password, path string) (io.ReadCloser, int64, error) {
 
 	sshConn, err := ssh.Dial("tcp", addr, sshConfig)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 
 	sftpClient, err := sftp.NewClient(sshConn)
+	if err != nil {
+		sshConn.Close()
+		return nil, nil, err
+	}
+
+	return sshConn, sftpClient, nil
+}
+
+func SFTPOpen(addr, user, password, path string) (io.ReadCloser, int64, error) {
+	_, sftpClient, err := sftpDial(addr, user, password)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -100,3 +163,54 @@ func SFTPOpen(addr, user, password, path string) (io.ReadCloser, int64, error) {
 
 	return bufReadCloser, fileInfo.Size(), nil
 }
+
+// SFTPUpload stores the content of r as path on the SFTP server. Like Upload
+// the content is uploaded to a temporary file which is renamed afterwards.
+func SFTPUpload(addr, user, password, path string, r io.Reader) error {
+	sshConn, sftpClient, err := sftpDial(addr, user, password)
+	if err != nil {
+		return err
+	}
+	defer sshConn.Close()
+	defer sftpClient.Close()
+
+	tmp := path + ".tmp"
+	file, err := sftpClient.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		sftpClient.Remove(tmp)
+		return err
+	}
+
+	// the rename of SFTP fails if path exists. the posix-rename extension
+	// replaces it atomically but is not supported by all servers.
+	err = sftpClient.PosixRename(tmp, path)
+	if err != nil {
+		err = sftpClient.Rename(tmp, path)
+	}
+	if err != nil && sftpExists(sftpClient, path) {
+		// only an existing path is removed, other errors (e.g.
+		// permissions) keep the existing file
+		if err = sftpClient.Remove(path); err == nil {
+			err = sftpClient.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		// tmp is kept, so the upload is not lost if path was deleted
+		return fmt.Errorf("failed to rename %s to %s: %w", tmp, path, err)
+	}
+
+	return nil
+}
+
+// sftpExists returns true if the file path exists on the SFTP server.
+func sftpExists(client *sftp.Client, path string) bool {
+	_, err := client.Stat(path)
+	return err == nil
+}
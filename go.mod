@@ -1,6 +1,6 @@
 module github.com/dvob/mip
 
-go 1.12
+go 1.13
 
 require (
 	github.com/fsnotify/fsnotify v1.4.7
@@ -14,6 +14,7 @@ require (
 	github.com/pelletier/go-toml v1.2.0
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/pkg/sftp v1.10.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/spf13/afero v1.1.1
 	github.com/spf13/cast v1.2.0
 	github.com/spf13/cobra v0.0.3
@@ -22,9 +23,11 @@ require (
 	github.com/spf13/viper v1.0.2
 	github.com/stretchr/testify v1.4.0 // indirect
 	github.com/tealeg/xlsx v1.0.3
+	github.com/ulikunitz/xz v0.5.10
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/crypto v0.0.0-20190820162420-60c769a6c586
-	golang.org/x/sys v0.0.0-20190412213103-97732733099d
-	golang.org/x/text v0.3.0
+	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5
+	golang.org/x/text v0.3.3
 	gopkg.in/cheggaaa/pb.v1 v1.0.25
 	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,185 @@
+package mip
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// RunHistory is the result of the last successful run of an importer. It is
+// stored by the Guard to compare the next run against it.
+type RunHistory struct {
+	Time     time.Time          `json:"time"`
+	Articles int                `json:"articles"`
+	Ignored  int                `json:"ignored"`
+	Skipped  int                `json:"skipped"`
+	Exported int                `json:"exported"`
+	Prices   map[string]float64 `json:"prices"`
+}
+
+// Guard is a RecordWriter which holds back the records of an import until
+// the import is checked against the configured thresholds. The thresholds
+// are read from cfg:
+//
+//	min_articles               minimal number of exported articles
+//	max_drop_percent           maximal drop of exported articles compared to
+//	                           the previous run
+//	max_price_change_percent   a price which changes by more than this is
+//	                           counted as changed...
+//	max_price_change_share     ...and at most this percentage of the articles
+//	                           may have a changed price
+//
+// The previous run is read from historyDir. If historyDir is empty only
+// min_articles is checked.
+type Guard struct {
+	name       string
+	cfg        *viper.Viper
+	historyDir string
+	buffer     RecordBuffer
+	// history is the run to store by SaveHistory, nil before Commit
+	history *RunHistory
+}
+
+func NewGuard(name string, cfg *viper.Viper, historyDir string) *Guard {
+	if cfg == nil {
+		cfg = viper.New()
+	}
+	return &Guard{
+		name:       name,
+		cfg:        cfg,
+		historyDir: historyDir,
+	}
+}
+
+func (g *Guard) WriteRecord(r *Record) error {
+	return g.buffer.WriteRecord(r)
+}
+
+// Records returns the records held back by the guard.
+func (g *Guard) Records() []*Record {
+	return g.buffer.Records
+}
+
+// Check checks the records held back against the thresholds.
+func (g *Guard) Check() error {
+	exported := len(g.buffer.Records)
+
+	minArticles := g.cfg.GetInt("min_articles")
+	if exported < minArticles {
+		return guardErrorf("%d articles exported but at least %d are required", exported, minArticles)
+	}
+
+	prev, err := g.previousRun()
+	if err != nil || prev == nil {
+		return err
+	}
+
+	if g.cfg.IsSet("max_drop_percent") && prev.Exported > 0 {
+		drop := float64(prev.Exported-exported) / float64(prev.Exported) * 100
+		if drop > g.cfg.GetFloat64("max_drop_percent") {
+			return guardErrorf("%d articles exported but %d in the previous run (drop of %.1f%%, max. %.1f%%)",
+				exported, prev.Exported, drop, g.cfg.GetFloat64("max_drop_percent"))
+		}
+	}
+
+	if g.cfg.IsSet("max_price_change_share") {
+		maxChange := g.cfg.GetFloat64("max_price_change_percent")
+		compared, changed := 0, 0
+		for _, r := range g.buffer.Records {
+			old, ok := prev.Prices[r.Key()]
+			if !ok || old == 0 {
+				continue
+			}
+			compared++
+			if math.Abs(r.PurchasePrice.Float64()-old)/old*100 > maxChange {
+				changed++
+			}
+		}
+		if compared > 0 {
+			share := float64(changed) / float64(compared) * 100
+			if share > g.cfg.GetFloat64("max_price_change_share") {
+				return guardErrorf("the price of %.1f%% of the articles changed by more than %.1f%% (max. %.1f%%)",
+					share, maxChange, g.cfg.GetFloat64("max_price_change_share"))
+			}
+		}
+	}
+	return nil
+}
+
+// Commit checks the records held back and if the check passes writes them to
+// w. The run is stored as history for the next check by SaveHistory.
+func (g *Guard) Commit(w RecordWriter, s *ImportSummary) error {
+	if err := g.Check(); err != nil {
+		return err
+	}
+	h := &RunHistory{
+		Time:     time.Now(),
+		Articles: s.Articles,
+		Ignored:  s.Ignored,
+		Skipped:  s.Skipped,
+		Exported: len(g.buffer.Records),
+		Prices:   make(map[string]float64, len(g.buffer.Records)),
+	}
+	for _, r := range g.buffer.Records {
+		h.Prices[r.Key()] = r.PurchasePrice.Float64()
+	}
+	if err := g.buffer.Flush(w); err != nil {
+		return err
+	}
+	g.history = h
+	return nil
+}
+
+// SaveHistory stores the run committed by Commit as history for the next
+// check. It has to be called after the export was written, so the next run
+// is not compared against prices which were never delivered.
+func (g *Guard) SaveHistory() error {
+	if g.history == nil {
+		return nil
+	}
+	return g.saveRun(g.history)
+}
+
+func (g *Guard) historyFile() string {
+	return filepath.Join(g.historyDir, g.name+".json")
+}
+
+func (g *Guard) previousRun() (*RunHistory, error) {
+	if g.historyDir == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(g.historyFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, sourceErrorf("failed to read history: %w", err)
+	}
+	h := &RunHistory{}
+	if err := json.Unmarshal(content, h); err != nil {
+		return nil, parseErrorf("failed to read history '%s': %w", g.historyFile(), err)
+	}
+	return h, nil
+}
+
+func (g *Guard) saveRun(h *RunHistory) error {
+	if g.historyDir == "" {
+		return nil
+	}
+	content, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(g.historyDir, 0755); err != nil {
+		return outputErrorf("failed to write history: %w", err)
+	}
+	if err := ioutil.WriteFile(g.historyFile(), content, 0644); err != nil {
+		return outputErrorf("failed to write history: %w", err)
+	}
+	return nil
+}
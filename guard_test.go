@@ -0,0 +1,177 @@
+package mip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// guardRecords returns n records with the ids 0 to n-1 and the price 10.00.
+func guardRecords(n int) []*Record {
+	records := make([]*Record, n)
+	for i := range records {
+		records[i] = &Record{Id: fmt.Sprint(i), PurchasePrice: 100000}
+	}
+	return records
+}
+
+// guardHistory returns a previous run with n exported articles and the price
+// 10.00 for each of them.
+func guardHistory(n int) *RunHistory {
+	h := &RunHistory{Exported: n, Prices: map[string]float64{}}
+	for i := 0; i < n; i++ {
+		h.Prices[fmt.Sprint(i)] = 10
+	}
+	return h
+}
+
+func TestGuardCheck(t *testing.T) {
+	// 3 of the 10 articles changed their price by 50%
+	changed := guardRecords(10)
+	for _, r := range changed[:3] {
+		r.PurchasePrice = 150000
+	}
+	// the previous run had no price for the changed articles
+	unknown := guardHistory(10)
+	for i := 0; i < 3; i++ {
+		delete(unknown.Prices, fmt.Sprint(i))
+	}
+	zero := guardHistory(10)
+	for i := 0; i < 3; i++ {
+		zero.Prices[fmt.Sprint(i)] = 0
+	}
+
+	for _, test := range []struct {
+		name     string
+		settings map[string]interface{}
+		prev     *RunHistory
+		records  []*Record
+		err      bool
+	}{
+		{"no thresholds", nil, nil, nil, false},
+		{"min_articles reached", map[string]interface{}{"min_articles": 10}, nil, guardRecords(10), false},
+		{"min_articles not reached", map[string]interface{}{"min_articles": 11}, nil, guardRecords(10), true},
+		{"min_articles without records", map[string]interface{}{"min_articles": 1}, nil, nil, true},
+		{"drop without history", map[string]interface{}{"max_drop_percent": 10}, nil, guardRecords(1), false},
+		{"drop within limit", map[string]interface{}{"max_drop_percent": 10}, guardHistory(100), guardRecords(90), false},
+		{"drop over limit", map[string]interface{}{"max_drop_percent": 10}, guardHistory(100), guardRecords(89), true},
+		{"drop to zero", map[string]interface{}{"max_drop_percent": 10}, guardHistory(100), nil, true},
+		{"growth", map[string]interface{}{"max_drop_percent": 0}, guardHistory(10), guardRecords(20), false},
+		{"previous run without articles", map[string]interface{}{"max_drop_percent": 10}, guardHistory(0), nil, false},
+		{"price changes within share", map[string]interface{}{"max_price_change_percent": 20, "max_price_change_share": 30}, guardHistory(10), changed, false},
+		{"price changes over share", map[string]interface{}{"max_price_change_percent": 20, "max_price_change_share": 29}, guardHistory(10), changed, true},
+		{"price changes within percent", map[string]interface{}{"max_price_change_percent": 50, "max_price_change_share": 0}, guardHistory(10), changed, false},
+		{"price changes of unknown articles", map[string]interface{}{"max_price_change_percent": 20, "max_price_change_share": 0}, unknown, changed, false},
+		{"price changes from zero", map[string]interface{}{"max_price_change_percent": 20, "max_price_change_share": 0}, zero, changed, false},
+		{"price changes without history", map[string]interface{}{"max_price_change_percent": 20, "max_price_change_share": 0}, nil, changed, false},
+		{"price changes against empty history", map[string]interface{}{"max_price_change_percent": 20, "max_price_change_share": 0}, guardHistory(0), changed, false},
+	} {
+		dir, err := ioutil.TempDir("", "mip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.prev != nil {
+			content, err := json.Marshal(test.prev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "test.json"), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cfg := viper.New()
+		for key, value := range test.settings {
+			cfg.Set(key, value)
+		}
+		g := NewGuard("test", cfg, dir)
+		for _, r := range test.records {
+			g.WriteRecord(r)
+		}
+		err = g.Check()
+		os.RemoveAll(dir)
+		if test.err != (err != nil) {
+			t.Errorf("%s: Check returned %v", test.name, err)
+		} else if err != nil && KindOf(err) != KindGuard {
+			t.Errorf("%s: Check returned %v, want a guard error", test.name, err)
+		}
+	}
+}
+
+func TestGuardHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := viper.New()
+	cfg.Set("max_drop_percent", 10)
+
+	g := NewGuard("test", cfg, dir)
+	for _, r := range guardRecords(10) {
+		g.WriteRecord(r)
+	}
+	out := &RecordBuffer{}
+	if err := g.Commit(out, NewImportSummary()); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Records) != 10 {
+		t.Errorf("%d records committed, want 10", len(out.Records))
+	}
+	// the history is only written by SaveHistory
+	if _, err := os.Stat(filepath.Join(dir, "test.json")); !os.IsNotExist(err) {
+		t.Errorf("history written by Commit: %v", err)
+	}
+	if err := g.SaveHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	g = NewGuard("test", cfg, dir)
+	for _, r := range guardRecords(5) {
+		g.WriteRecord(r)
+	}
+	if err := g.Check(); err == nil {
+		t.Error("drop against the saved history not detected")
+	}
+}
+
+func TestGuardHistoryErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := viper.New()
+	cfg.Set("max_drop_percent", 10)
+
+	// a missing history is no error
+	if err := NewGuard("missing", cfg, dir).Check(); err != nil {
+		t.Errorf("missing history: %s", err)
+	}
+	// without history directory the history is not used
+	if err := NewGuard("test", cfg, "").Check(); err != nil {
+		t.Errorf("without history_dir: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "corrupt.json"), []byte(`{"exported": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = NewGuard("corrupt", cfg, dir).Check()
+	if err == nil {
+		t.Error("corrupt history: no error")
+	} else if KindOf(err) != KindParse {
+		t.Errorf("corrupt history: %v, want a parse error", err)
+	}
+
+	// the history file is a directory
+	if err := os.Mkdir(filepath.Join(dir, "dir.json"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewGuard("dir", cfg, dir).Check(); err == nil {
+		t.Error("unreadable history: no error")
+	}
+}
@@ -0,0 +1,162 @@
+package mip
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/tealeg/xlsx"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zipMagic   = []byte("PK\x03\x04")
+	tarMagic   = []byte("ustar")
+)
+
+// tarMagicOffset is the position of the magic field in a tar header
+const tarMagicOffset = 257
+
+// Unpack returns a reader to the uncompressed content of r. gzip, bzip2 and
+// xz compressed streams are detected by their magic bytes and decompressed
+// transparently. If member is not empty, the content has to be a zip or tar
+// archive and the first member whose name or base name matches the pattern
+// member (see path.Match) is returned.
+func Unpack(r io.Reader, member string) (io.Reader, error) {
+	var err error
+	br := bufio.NewReader(r)
+	for {
+		head, _ := br.Peek(len(xzMagic))
+		switch {
+		case bytes.HasPrefix(head, gzipMagic):
+			r, err = gzip.NewReader(br)
+		case bytes.HasPrefix(head, bzip2Magic):
+			r = bzip2.NewReader(br)
+		case bytes.HasPrefix(head, xzMagic):
+			r, err = xz.NewReader(br)
+		default:
+			if member == "" {
+				return br, nil
+			}
+			return unpackMember(br, member)
+		}
+		if err != nil {
+			return nil, parseErrorf("failed to decompress input: %w", err)
+		}
+		br = bufio.NewReader(r)
+	}
+}
+
+func unpackMember(br *bufio.Reader, member string) (io.Reader, error) {
+	if _, err := path.Match(member, ""); err != nil {
+		return nil, configErrorf("invalid member pattern '%s': %w", member, err)
+	}
+
+	head, _ := br.Peek(tarMagicOffset + len(tarMagic))
+	if bytes.HasPrefix(head, zipMagic) {
+		// zip needs random access, so we have to read the whole archive
+		content, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, sourceErrorf("failed to read zip archive: %w", err)
+		}
+		return unpackZipMember(content, member)
+	}
+	if len(head) == tarMagicOffset+len(tarMagic) && bytes.Equal(head[tarMagicOffset:], tarMagic) {
+		return unpackTarMember(br, member)
+	}
+	return nil, parseErrorf("member '%s' requested but input is neither a zip nor a tar archive", member)
+}
+
+func unpackZipMember(content []byte, member string) (io.Reader, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, parseErrorf("invalid zip archive: %w", err)
+	}
+	for _, f := range zipReader.File {
+		if !matchMember(member, f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, parseErrorf("failed to open zip member '%s': %w", f.Name, err)
+		}
+		// the archive is in memory anyway, so the member is read completely
+		// and the reader is closed right away. Close verifies the checksum.
+		data, err := ioutil.ReadAll(rc)
+		if err1 := rc.Close(); err == nil {
+			err = err1
+		}
+		if err != nil {
+			return nil, parseErrorf("failed to read zip member '%s': %w", f.Name, err)
+		}
+		return bytes.NewReader(data), nil
+	}
+	return nil, parseErrorf("no member matching '%s' in zip archive", member)
+}
+
+func unpackTarMember(r io.Reader, member string) (io.Reader, error) {
+	tarReader := tar.NewReader(r)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, parseErrorf("no member matching '%s' in tar archive", member)
+		}
+		if err != nil {
+			return nil, parseErrorf("invalid tar archive: %w", err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if matchMember(member, hdr.Name) {
+			return tarReader, nil
+		}
+	}
+}
+
+func matchMember(pattern, name string) bool {
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	ok, _ := path.Match(pattern, path.Base(name))
+	return ok
+}
+
+// openXlsx reads a xlsx file from r. The content of r is unpacked with Unpack
+// first, so compressed spreadsheets or spreadsheets inside of an archive are
+// supported as well.
+func openXlsx(r io.Reader, member string) (*xlsx.File, error) {
+	r, err := Unpack(r, member)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, sourceErrorf("failed to read xlsx: %w", err)
+	}
+	xlFile, err := xlsx.OpenBinary(content)
+	if err != nil {
+		return nil, parseErrorf("invalid xlsx: %w", err)
+	}
+	return xlFile, nil
+}
+
+// openXlsxFile is like openXlsx but reads from the file under path. The file
+// is recorded as source in the summary ps.
+func openXlsxFile(path, member string, ps *ImportSummary) (*xlsx.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, sourceErrorf("%w", err)
+	}
+	defer f.Close()
+	return openXlsx(ps.trackSource(path, f), member)
+}
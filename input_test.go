@@ -0,0 +1,154 @@
+package mip
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+const unpackContent = "id;preis\n1;2.50\n"
+
+// bzip2Content is unpackContent compressed with bzip2, the standard library
+// has no bzip2 writer.
+var bzip2Content = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xf5, 0x80, 0x00, 0x6d, 0x00, 0x00,
+	0x05, 0xd9, 0x80, 0x00, 0x10, 0x00, 0x01, 0x72, 0x08, 0x06, 0x20, 0x58, 0x00, 0x20, 0x00, 0x31,
+	0x00, 0xd0, 0x01, 0x4d, 0x19, 0x90, 0x68, 0xbf, 0x01, 0xc8, 0x33, 0x68, 0x61, 0x51, 0xa2, 0xee,
+	0x48, 0xa7, 0x0a, 0x12, 0x1e, 0xb0, 0x00, 0x0d, 0xa0,
+}
+
+func gzipped(t *testing.T, content []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func xzCompressed(t *testing.T, content []byte) []byte {
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipArchive returns a zip archive with the members name and content in
+// alternating order.
+func zipArchive(t *testing.T, members ...string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i := 0; i < len(members); i += 2 {
+		f, err := w.Create(members[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(f, members[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tarArchive is like zipArchive but returns a tar archive. A directory entry
+// is added for every member in a directory.
+func tarArchive(t *testing.T, members ...string) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for i := 0; i < len(members); i += 2 {
+		if dir := members[i][:strings.LastIndex(members[i], "/")+1]; dir != "" {
+			if err := w.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hdr := &tar.Header{Name: members[i], Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(members[i+1]))}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, members[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnpack(t *testing.T) {
+	content := []byte(unpackContent)
+	zipped := zipArchive(t, "readme.txt", "readme", "data/preise.csv", unpackContent, "data/alt.csv", "alt")
+	tarred := tarArchive(t, "readme.txt", "readme", "data/preise.csv", unpackContent, "data/alt.csv", "alt")
+
+	for _, test := range []struct {
+		name   string
+		input  []byte
+		member string
+		want   string
+		err    bool
+		kind   ErrorKind
+	}{
+		{name: "plain", input: content, want: unpackContent},
+		{name: "empty", input: nil, want: ""},
+		{name: "gzip", input: gzipped(t, content), want: unpackContent},
+		{name: "bzip2", input: bzip2Content, want: unpackContent},
+		{name: "xz", input: xzCompressed(t, content), want: unpackContent},
+		{name: "gzip in xz", input: xzCompressed(t, gzipped(t, content)), want: unpackContent},
+		{name: "zip member by name", input: zipped, member: "data/preise.csv", want: unpackContent},
+		{name: "zip member by base name", input: zipped, member: "preise.csv", want: unpackContent},
+		// the first matching member is returned
+		{name: "zip member by pattern", input: zipped, member: "*.csv", want: unpackContent},
+		{name: "zip member by path pattern", input: zipped, member: "data/a*", want: "alt"},
+		{name: "gzipped zip", input: gzipped(t, zipped), member: "*.csv", want: unpackContent},
+		{name: "zip member missing", input: zipped, member: "*.xlsx", err: true, kind: KindParse},
+		{name: "tar member by name", input: tarred, member: "data/preise.csv", want: unpackContent},
+		{name: "tar member by pattern", input: tarred, member: "*.csv", want: unpackContent},
+		// the directory data/ is no member
+		{name: "tar directory", input: tarred, member: "data", err: true, kind: KindParse},
+		{name: "gzipped tar", input: gzipped(t, tarred), member: "preise.csv", want: unpackContent},
+		{name: "tar member missing", input: tarred, member: "*.xlsx", err: true, kind: KindParse},
+		{name: "neither zip nor tar", input: gzipped(t, content), member: "*.csv", err: true, kind: KindParse},
+		{name: "invalid pattern", input: zipped, member: "[", err: true, kind: KindConfig},
+		{name: "corrupt gzip", input: gzipMagic, err: true, kind: KindParse},
+	} {
+		r, err := Unpack(bytes.NewReader(test.input), test.member)
+		var got []byte
+		if err == nil {
+			got, err = ioutil.ReadAll(r)
+		}
+		if test.err != (err != nil) {
+			t.Errorf("%s: Unpack returned %v", test.name, err)
+			continue
+		}
+		if err != nil {
+			if KindOf(err) != test.kind {
+				t.Errorf("%s: Unpack returned %v, want kind %v", test.name, err, test.kind)
+			}
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: content %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package mip
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Lock is a lock file which prevents two processes from running the same
+// importer at the same time, e.g. a run started by cron and one of 'mip
+// serve'. The lock is held by the operating system, so it is released if the
+// process dies.
+type Lock struct {
+	file *os.File
+}
+
+// AcquireLock locks name in dir. It fails immediately if another process or
+// another run of this process holds the lock.
+func AcquireLock(dir, name string) (*Lock, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, outputErrorf("failed to create lock: %w", err)
+	}
+	path := filepath.Join(dir, name+".lock")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, outputErrorf("failed to create lock: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s is already running (locked by %s)", name, path)
+	}
+	// the pid helps to find the process holding the lock
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	return &Lock{file: file}, nil
+}
+
+// Release releases the lock. The lock file is kept, removing it would race
+// with a process which just opened it.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlockFile(l.file)
+	if err1 := l.file.Close(); err == nil {
+		err = err1
+	}
+	l.file = nil
+	return err
+}
@@ -1,11 +1,15 @@
 package mip
 
 import (
+	"bufio"
 	"fmt"
+	"github.com/spf13/viper"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 	"io"
-	"strings"
+	"sort"
 	"time"
 )
 
@@ -15,34 +19,161 @@ type Importer interface {
 	Run() (*ImportSummary, error)
 }
 
+// Importers contains the constructors of all importers by the name of their
+// configuration section.
+var Importers = map[string]func(cfg *viper.Viper, output RecordWriter) Importer{
+	"alltron": func(cfg *viper.Viper, output RecordWriter) Importer { return NewAlltronImport(cfg, output) },
+	"mitel":   func(cfg *viper.Viper, output RecordWriter) Importer { return NewMitelImport(cfg, output) },
+	"suprag":  func(cfg *viper.Viper, output RecordWriter) Importer { return NewSupragImport(cfg, output) },
+}
+
+// ImporterNames returns the names of all importers in the order they are run
+// by 'mip all'.
+func ImporterNames() []string {
+	names := make([]string, 0, len(Importers))
+	for name := range Importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RecordWriter receives the records of an import.
+type RecordWriter interface {
+	WriteRecord(r *Record) error
+}
+
+// RecordBuffer is a RecordWriter which keeps all records in memory.
+type RecordBuffer struct {
+	Records []*Record
+}
+
+func (b *RecordBuffer) WriteRecord(r *Record) error {
+	b.Records = append(b.Records, r)
+	return nil
+}
+
+// Flush writes all buffered records to w and empties the buffer.
+func (b *RecordBuffer) Flush(w RecordWriter) error {
+	for _, r := range b.Records {
+		if err := w.WriteRecord(r); err != nil {
+			return err
+		}
+	}
+	b.Records = nil
+	return nil
+}
+
+type multiRecordWriter []RecordWriter
+
+func (mw multiRecordWriter) WriteRecord(r *Record) error {
+	for _, w := range mw {
+		if err := w.WriteRecord(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MultiRecordWriter returns a RecordWriter which writes each record to all
+// writers, similar to io.MultiWriter.
+func MultiRecordWriter(writers ...RecordWriter) RecordWriter {
+	return multiRecordWriter(writers)
+}
+
 type Export struct {
-	w      io.Writer
-	offset int64
+	w *bufio.Writer
+	// enc is the encoding writer, if any. it has to be closed to flush it
+	enc     io.WriteCloser
+	offset  int64
+	columns []ExportColumn
+	format  *CSVFormat
+	// unmappable handles the characters which the encoding can not
+	// represent, nil if the encoding can represent all characters or they
+	// are escaped as HTML
+	unmappable *unmappableRunes
 }
 
+// Encodings are the output encodings by name. Without encoding the output is
+// UTF-8.
 var Encodings = map[string]encoding.Encoding{
-	"iso-8859-1": charmap.ISO8859_1,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso-8859-15":  charmap.ISO8859_15,
+	"windows-1252": charmap.Windows1252,
+	"utf-8-bom":    unicode.UTF8BOM,
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
 }
 
-func NewExport(output io.Writer, enc string) (*Export, error) {
+// policies for the characters which the output encoding can not represent
+const (
+	// UnmappableHTML replaces the characters with HTML entities (e.g. &#8364;)
+	UnmappableHTML = "html"
+	// UnmappableTransliterate replaces the characters with similar ones
+	// (e.g. € with EUR) or ? if there is none
+	UnmappableTransliterate = "transliterate"
+	// UnmappableReplace replaces the characters with ?
+	UnmappableReplace = "replace"
+	// UnmappableFail exports the records with ? but the export fails on
+	// Close with a list of the affected records
+	UnmappableFail = "fail"
+)
+
+// NewExport returns an export to output with the encoding enc. The characters
+// which enc can not represent are handled according to the policy unmappable
+// (default UnmappableHTML).
+func NewExport(output io.Writer, enc, unmappable string) (*Export, error) {
+	// the policy is validated even if UTF-8 needs none, so a typo does not
+	// go unnoticed until the encoding is changed
+	switch unmappable {
+	case "", UnmappableHTML, UnmappableTransliterate, UnmappableReplace, UnmappableFail:
+	default:
+		return &Export{}, configErrorf("unknown policy for unmappable characters '%s'", unmappable)
+	}
 	// no conversion needed
 	if enc == "utf8" || enc == "" {
-		return &Export{output, 0}, nil
+		return &Export{w: bufio.NewWriter(output), columns: DefaultColumns, format: DefaultCSVFormat}, nil
 	}
 
 	targetEnc, ok := Encodings[enc]
 	if !ok {
-		return &Export{}, fmt.Errorf("unknown encoding '%s'", enc)
+		return &Export{}, configErrorf("unknown encoding '%s'", enc)
+	}
+	e := &Export{columns: DefaultColumns, format: DefaultCSVFormat}
+	var encoder transform.Transformer
+	switch unmappable {
+	case "", UnmappableHTML:
+		encoder = encoding.HTMLEscapeUnsupported(targetEnc.NewEncoder())
+	default:
+		e.unmappable = newUnmappableRunes(enc, targetEnc, unmappable)
+		// the records are cleaned before, so there is nothing to replace
+		encoder = encoding.ReplaceUnsupported(targetEnc.NewEncoder())
 	}
-	output = encoding.HTMLEscapeUnsupported(targetEnc.NewEncoder()).Writer(output)
-	return &Export{output, 0}, nil
+	e.enc = transform.NewWriter(output, encoder)
+	e.w = bufio.NewWriter(e.enc)
+	return e, nil
+}
+
+// SetColumns sets the columns of the export. It has to be called before the
+// first record is written.
+func (e *Export) SetColumns(columns []ExportColumn) {
+	e.columns = columns
+}
+
+// SetFormat sets the CSV format of the export. It has to be called before
+// the first record is written.
+func (e *Export) SetFormat(format *CSVFormat) {
+	e.format = format
 }
 
 func (e *Export) Write(p []byte) (n int, err error) {
 	bytes := 0
 	if e.offset == 0 {
-		io.WriteString(e.w, FormatHeader())
-		bytes += len(FormatHeader())
+		header, err := e.format.formatHeader(e.columns)
+		if err != nil {
+			return 0, err
+		}
+		io.WriteString(e.w, header)
+		bytes += len(header)
 	}
 	i, err := e.w.Write(p)
 	bytes += i
@@ -50,49 +181,134 @@ func (e *Export) Write(p []byte) (n int, err error) {
 	return bytes, err
 }
 
+func (e *Export) WriteRecord(r *Record) error {
+	fields := e.format.recordFields(r, e.columns)
+	if e.unmappable != nil {
+		fields = e.unmappable.clean(r, fields, e.format)
+	}
+	line, err := e.format.formatRow(fields)
+	if err == nil {
+		_, err = io.WriteString(e, line)
+	}
+	if err != nil {
+		return outputErrorf("failed to write record: %w", err)
+	}
+	return nil
+}
+
+// Close flushes all buffered data to the underlying writer. It does not
+// close the underlying writer itself.
+func (e *Export) Close() error {
+	err := e.w.Flush()
+	if e.enc != nil {
+		if err1 := e.enc.Close(); err == nil {
+			err = err1
+		}
+	}
+	if err != nil {
+		return outputErrorf("failed to write output: %w", err)
+	}
+	if e.unmappable != nil {
+		return e.unmappable.err()
+	}
+	return nil
+}
+
 type Record struct {
 	Id             string
 	IdPrefix       string
 	Description    string
-	PurchasePrice  float64
+	PurchasePrice  Money
 	PurchaseFactor float64
 	SellingFactor  float64
-	SellingPrice   float64
+	SellingPrice   Money
 	Category       string
 	CategoryNumber string
+	// PriceTiers are the prices for larger quantities sorted by quantity.
+	// PurchasePrice and SellingPrice are the prices of a single unit.
+	PriceTiers []PriceTier
+
+	// Supplier is the name of the importer which imported the record
+	Supplier   string
+	Attributes Attributes
 }
 
+// Key identifies a record in the export.
+func (r *Record) Key() string {
+	return r.IdPrefix + r.Id
+}
+
+// FormatLine formats r as line of the export with the default format.
 func (r *Record) FormatLine() string {
-	replacer := strings.NewReplacer(",", " ", "\"", "\"\"")
-	return fmt.Sprintf("\"%s%s\",\"%s\",\"%s\",\"%.2f\",\"%f\",\"%.2f\",\"%f\",\"%s\"\n",
-		r.IdPrefix,
-		r.Id,
-		replacer.Replace(r.Description),
-		r.Category,
-		r.PurchasePrice,
-		r.PurchaseFactor,
-		r.SellingPrice,
-		r.SellingFactor,
-		r.CategoryNumber)
+	line, _ := DefaultCSVFormat.formatRecord(r, DefaultColumns)
+	return line
+}
+
+func (f *CSVFormat) formatRecord(r *Record, columns []ExportColumn) (string, error) {
+	return f.formatRow(f.recordFields(r, columns))
 }
 
+// recordFields returns the values of the columns of r.
+func (f *CSVFormat) recordFields(r *Record, columns []ExportColumn) []string {
+	fields := make([]string, len(columns))
+	for n, c := range columns {
+		fields[n] = c.value(r, f)
+	}
+	return fields
+}
+
+// FormatHeader returns the header of the export with the default format.
 func FormatHeader() string {
-	return "\"Id\",\"Beschreibung\",\"Kategorie\",\"Einkaufspreis\",\"Einkaufsfaktor\",\"Verkaufspreis\",\"Verkaufsfaktor\",\"Kategorie-Nummer\"\n"
+	header, _ := DefaultCSVFormat.formatHeader(DefaultColumns)
+	return header
+}
+
+func (f *CSVFormat) formatHeader(columns []ExportColumn) (string, error) {
+	titles := make([]string, len(columns))
+	for n, c := range columns {
+		titles[n] = c.Title
+	}
+	return f.formatRow(titles)
 }
 
 type ImportSummary struct {
-	start    time.Time
-	end      time.Time
-	Articles int
-	Ignored  int
+	start           time.Time
+	end             time.Time
+	Articles        int
+	Ignored         int
+	IgnoredByReason map[string]int
+	Skipped         int
+	SkippedByReason map[string]int
+	Rejections      []*Rejection
+	Sources         []*Source
+	// UnmappedCategories contains the number of articles by supplier
+	// category for which the category mapping had no rule
+	UnmappedCategories map[string]int
+	// ExchangeRate is the rate the prices were converted with, nil if they
+	// were not converted
+	ExchangeRate *ExchangeRate
+}
+
+// Rejection describes an input entry which could not be exported because of
+// invalid data.
+type Rejection struct {
+	// Ref is the position in the input (e.g. "line 12")
+	Ref    string `json:"ref"`
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
 }
 
 func NewImportSummary() *ImportSummary {
-	return &ImportSummary{}
+	return &ImportSummary{
+		IgnoredByReason:    make(map[string]int),
+		SkippedByReason:    make(map[string]int),
+		UnmappedCategories: make(map[string]int),
+	}
 }
 
 func StartImportSummary() *ImportSummary {
-	ps := &ImportSummary{}
+	ps := NewImportSummary()
 	ps.Start()
 	return ps
 }
@@ -100,10 +316,46 @@ func StartImportSummary() *ImportSummary {
 func (ps *ImportSummary) Add(a *ImportSummary) {
 	ps.Articles += a.Articles
 	ps.Ignored += a.Ignored
+	ps.Skipped += a.Skipped
+	for reason, n := range a.IgnoredByReason {
+		ps.IgnoredByReason[reason] += n
+	}
+	for reason, n := range a.SkippedByReason {
+		ps.SkippedByReason[reason] += n
+	}
+	for category, n := range a.UnmappedCategories {
+		ps.UnmappedCategories[category] += n
+	}
+	ps.Rejections = append(ps.Rejections, a.Rejections...)
+	ps.Sources = append(ps.Sources, a.Sources...)
+}
+
+// Ignore counts an article which was deliberately not exported (e.g. due to
+// the configuration).
+func (ps *ImportSummary) Ignore(reason string) {
+	ps.Ignored++
+	ps.IgnoredByReason[reason]++
+}
+
+// Reject records an article which could not be exported because of invalid
+// input data. It is counted as skipped.
+func (ps *ImportSummary) Reject(r *Rejection) {
+	ps.Skipped++
+	ps.SkippedByReason[r.Reason]++
+	ps.Rejections = append(ps.Rejections, r)
+}
+
+// BytesDownloaded returns the number of bytes read from all sources.
+func (ps *ImportSummary) BytesDownloaded() int64 {
+	var n int64
+	for _, src := range ps.Sources {
+		n += src.Bytes
+	}
+	return n
 }
 
 func (ps *ImportSummary) String() string {
-	return fmt.Sprintf("processed %d articles in %s (ignored : %d)", ps.Articles, ps.Duration(), ps.Ignored)
+	return fmt.Sprintf("processed %d articles in %s (ignored : %d, skipped: %d)", ps.Articles, ps.Duration(), ps.Ignored, ps.Skipped)
 }
 
 func (ps *ImportSummary) Start() {
@@ -0,0 +1,340 @@
+package mip
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics collects the results of import runs and exposes them in the
+// Prometheus text format, either as textfile for the node_exporter or over
+// HTTP.
+type Metrics struct {
+	mu        sync.Mutex
+	importers map[string]*importerMetrics
+}
+
+type importerMetrics struct {
+	lastRun          time.Time
+	lastSuccess      time.Time
+	success          bool
+	duration         float64
+	articles         int
+	ignored          map[string]int
+	skipped          map[string]int
+	bytesDownloaded  int64
+	downloadDuration map[string]float64
+	runs             int
+	errors           map[string]int
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		importers: make(map[string]*importerMetrics),
+	}
+}
+
+// Observe adds the result of an importer run.
+func (m *Metrics) Observe(ir *ImporterReport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	im := m.importer(ir.Name)
+	im.lastRun = ir.End
+	if im.lastRun.IsZero() {
+		im.lastRun = time.Now()
+	}
+	im.runs++
+	im.success = ir.Status == StatusOK
+	if im.success {
+		im.lastSuccess = im.lastRun
+	} else {
+		im.errors[ir.ErrorKind]++
+	}
+	im.duration = ir.Duration
+	im.articles = ir.Articles
+	im.ignored = ir.IgnoredByReason
+	im.skipped = ir.SkippedByReason
+	im.bytesDownloaded = ir.BytesDownloaded
+	im.downloadDuration = make(map[string]float64)
+	for _, src := range ir.Sources {
+		im.downloadDuration[src.Name] = src.Duration
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text format to w.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.importers))
+	for name := range m.importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	metric := func(name, typ, help string, values func(add func(value float64, labels ...string))) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		values(func(value float64, labels ...string) {
+			buf.WriteString(name)
+			if len(labels) > 0 {
+				buf.WriteByte('{')
+				for i := 0; i+1 < len(labels); i += 2 {
+					if i > 0 {
+						buf.WriteByte(',')
+					}
+					fmt.Fprintf(buf, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+				}
+				buf.WriteByte('}')
+			}
+			fmt.Fprintf(buf, " %g\n", value)
+		})
+	}
+	perImporter := func(value func(im *importerMetrics) float64) func(add func(float64, ...string)) {
+		return func(add func(float64, ...string)) {
+			for _, name := range names {
+				add(value(m.importers[name]), "importer", name)
+			}
+		}
+	}
+	byKey := func(label string, values func(im *importerMetrics) map[string]float64) func(add func(float64, ...string)) {
+		return func(add func(float64, ...string)) {
+			for _, name := range names {
+				vals := values(m.importers[name])
+				for _, key := range sortedKeys(vals) {
+					add(vals[key], "importer", name, label, key)
+				}
+			}
+		}
+	}
+	intMap := func(m map[string]int) map[string]float64 {
+		f := make(map[string]float64, len(m))
+		for k, v := range m {
+			f[k] = float64(v)
+		}
+		return f
+	}
+
+	metric("mip_import_last_run_timestamp_seconds", "gauge", "Time of the last run of the importer.",
+		perImporter(func(im *importerMetrics) float64 { return unixSeconds(im.lastRun) }))
+	metric("mip_import_last_success_timestamp_seconds", "gauge", "Time of the last successful run of the importer.",
+		perImporter(func(im *importerMetrics) float64 { return unixSeconds(im.lastSuccess) }))
+	metric("mip_import_success", "gauge", "Whether the last run of the importer succeeded.",
+		perImporter(func(im *importerMetrics) float64 {
+			if im.success {
+				return 1
+			}
+			return 0
+		}))
+	metric("mip_import_duration_seconds", "gauge", "Duration of the last run of the importer.",
+		perImporter(func(im *importerMetrics) float64 { return im.duration }))
+	metric("mip_import_articles", "gauge", "Number of articles processed by the last run of the importer.",
+		perImporter(func(im *importerMetrics) float64 { return float64(im.articles) }))
+	metric("mip_import_ignored_articles", "gauge", "Number of articles ignored by the last run of the importer.",
+		byKey("reason", func(im *importerMetrics) map[string]float64 { return intMap(im.ignored) }))
+	metric("mip_import_skipped_articles", "gauge", "Number of articles skipped due to invalid data by the last run of the importer.",
+		byKey("reason", func(im *importerMetrics) map[string]float64 { return intMap(im.skipped) }))
+	metric("mip_import_downloaded_bytes", "gauge", "Number of bytes read from the sources by the last run of the importer.",
+		perImporter(func(im *importerMetrics) float64 { return float64(im.bytesDownloaded) }))
+	metric("mip_import_download_duration_seconds", "gauge", "Time spent reading a source in the last run of the importer.",
+		byKey("source", func(im *importerMetrics) map[string]float64 { return im.downloadDuration }))
+	metric("mip_import_runs_total", "counter", "Number of runs of the importer.",
+		perImporter(func(im *importerMetrics) float64 { return float64(im.runs) }))
+	metric("mip_import_errors_total", "counter", "Number of failed runs of the importer by kind of error.",
+		byKey("kind", func(im *importerMetrics) map[string]float64 { return intMap(im.errors) }))
+
+	return buf.WriteTo(w)
+}
+
+// ReadTextfile restores the metrics from a textfile written by WriteTextfile,
+// so the timestamps and counters of the importers are carried forward from
+// run to run of separate processes. A missing file is no error.
+func (m *Metrics) ReadTextfile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return sourceErrorf("failed to read metrics: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for n, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, labels, value, err := parseSample(line)
+		if err != nil {
+			return parseErrorf("invalid metrics file '%s' line %d: %w", path, n+1, err)
+		}
+		if labels["importer"] == "" {
+			continue
+		}
+		m.restore(m.importer(labels["importer"]), name, labels, value)
+	}
+	return nil
+}
+
+// importer returns the metrics of the importer name. It has to be called with
+// mu locked.
+func (m *Metrics) importer(name string) *importerMetrics {
+	im, ok := m.importers[name]
+	if !ok {
+		im = &importerMetrics{
+			ignored:          map[string]int{},
+			skipped:          map[string]int{},
+			downloadDuration: map[string]float64{},
+			errors:           map[string]int{},
+		}
+		m.importers[name] = im
+	}
+	return im
+}
+
+// restore sets the value of a sample written by WriteTo.
+func (m *Metrics) restore(im *importerMetrics, name string, labels map[string]string, value float64) {
+	switch name {
+	case "mip_import_last_run_timestamp_seconds":
+		im.lastRun = fromUnixSeconds(value)
+	case "mip_import_last_success_timestamp_seconds":
+		im.lastSuccess = fromUnixSeconds(value)
+	case "mip_import_success":
+		im.success = value == 1
+	case "mip_import_duration_seconds":
+		im.duration = value
+	case "mip_import_articles":
+		im.articles = int(value)
+	case "mip_import_ignored_articles":
+		im.ignored[labels["reason"]] = int(value)
+	case "mip_import_skipped_articles":
+		im.skipped[labels["reason"]] = int(value)
+	case "mip_import_downloaded_bytes":
+		im.bytesDownloaded = int64(value)
+	case "mip_import_download_duration_seconds":
+		im.downloadDuration[labels["source"]] = value
+	case "mip_import_runs_total":
+		im.runs = int(value)
+	case "mip_import_errors_total":
+		im.errors[labels["kind"]] = int(value)
+	}
+}
+
+// parseSample parses a line like name{label="value",...} 1.5 of the text
+// format.
+func parseSample(line string) (name string, labels map[string]string, value float64, err error) {
+	i := strings.LastIndexByte(line, ' ')
+	if i < 0 {
+		return "", nil, 0, fmt.Errorf("missing value")
+	}
+	value, err = strconv.ParseFloat(line[i+1:], 64)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("invalid value '%s'", line[i+1:])
+	}
+	series := line[:i]
+	labels = map[string]string{}
+	j := strings.IndexByte(series, '{')
+	if j < 0 {
+		return series, labels, value, nil
+	}
+	name, rest := series[:j], series[j+1:]
+	for {
+		rest = strings.TrimLeft(rest, ",")
+		if strings.HasPrefix(rest, "}") {
+			return name, labels, value, nil
+		}
+		eq := strings.Index(rest, "=\"")
+		if eq < 0 {
+			return "", nil, 0, fmt.Errorf("invalid labels '%s'", series)
+		}
+		key := rest[:eq]
+		rest = rest[eq+2:]
+		var b strings.Builder
+		for {
+			if rest == "" {
+				return "", nil, 0, fmt.Errorf("invalid labels '%s'", series)
+			}
+			c := rest[0]
+			rest = rest[1:]
+			if c == '"' {
+				break
+			}
+			if c == '\\' && rest != "" {
+				c = rest[0]
+				rest = rest[1:]
+				if c == 'n' {
+					c = '\n'
+				}
+			}
+			b.WriteByte(c)
+		}
+		labels[key] = b.String()
+	}
+}
+
+// ServeHTTP serves the metrics to a Prometheus server.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTextfile writes the metrics to path for the textfile collector of the
+// node_exporter. The file is replaced atomically as required by the collector.
+func (m *Metrics) WriteTextfile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return outputErrorf("failed to write metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = m.WriteTo(tmp)
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return outputErrorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+func fromUnixSeconds(s float64) time.Time {
+	if s == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(s*1e9))
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
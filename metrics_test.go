@@ -0,0 +1,115 @@
+package mip
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSample(t *testing.T) {
+	for _, test := range []struct {
+		line   string
+		name   string
+		labels map[string]string
+		value  float64
+	}{
+		{"mip_import_runs_total 3", "mip_import_runs_total", map[string]string{}, 3},
+		{`mip_import_runs_total{importer="alltron"} 3`, "mip_import_runs_total", map[string]string{"importer": "alltron"}, 3},
+		{`mip_import_last_run_timestamp_seconds{importer="alltron"} 1.6e+09`, "mip_import_last_run_timestamp_seconds", map[string]string{"importer": "alltron"}, 1.6e9},
+		{`mip_import_skipped_articles{importer="suprag",reason="invalid price"} 2`, "mip_import_skipped_articles", map[string]string{"importer": "suprag", "reason": "invalid price"}, 2},
+		{`mip_import_skipped_articles{importer="suprag",reason="a \"b\" c\\d\ne"} 1`, "mip_import_skipped_articles", map[string]string{"importer": "suprag", "reason": "a \"b\" c\\d\ne"}, 1},
+		{`mip_import_skipped_articles{importer="suprag",reason="a}, b"} 1`, "mip_import_skipped_articles", map[string]string{"importer": "suprag", "reason": "a}, b"}, 1},
+	} {
+		name, labels, value, err := parseSample(test.line)
+		if err != nil {
+			t.Errorf("parseSample(%q): %s", test.line, err)
+			continue
+		}
+		if name != test.name || value != test.value || fmt.Sprint(labels) != fmt.Sprint(test.labels) {
+			t.Errorf("parseSample(%q) = %s, %v, %g, want %s, %v, %g", test.line, name, labels, value, test.name, test.labels, test.value)
+		}
+	}
+	for _, line := range []string{
+		"mip_import_runs_total",
+		"mip_import_runs_total abc",
+		`mip_import_runs_total{importer} 1`,
+		`mip_import_runs_total{importer="alltron} 1`,
+	} {
+		if _, _, _, err := parseSample(line); err == nil {
+			t.Errorf("parseSample(%q) returned no error", line)
+		}
+	}
+}
+
+// the timestamps and counters of a run have to be carried forward to the runs
+// of later processes
+func TestMetricsTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mip.prom")
+
+	success := time.Date(2020, 3, 13, 2, 30, 0, 0, time.UTC)
+	m := NewMetrics()
+	if err := m.ReadTextfile(path); err != nil {
+		t.Fatalf("missing textfile: %s", err)
+	}
+	m.Observe(&ImporterReport{
+		Name:            "Suprag",
+		Status:          StatusOK,
+		End:             success,
+		Articles:        10,
+		SkippedByReason: map[string]int{`invalid "price"`: 2},
+	})
+	if err := m.WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	m = NewMetrics()
+	if err := m.ReadTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+	m.Observe(&ImporterReport{
+		Name:      "Suprag",
+		Status:    StatusFailed,
+		End:       success.Add(24 * time.Hour),
+		ErrorKind: KindSource.String(),
+	})
+	buf := &bytes.Buffer{}
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		fmt.Sprintf(`mip_import_last_success_timestamp_seconds{importer="Suprag"} %g`, unixSeconds(success)),
+		fmt.Sprintf(`mip_import_last_run_timestamp_seconds{importer="Suprag"} %g`, unixSeconds(success.Add(24*time.Hour))),
+		`mip_import_success{importer="Suprag"} 0`,
+		`mip_import_runs_total{importer="Suprag"} 2`,
+		fmt.Sprintf(`mip_import_errors_total{importer="Suprag",kind="%s"} 1`, KindSource),
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("metrics do not contain %s:\n%s", want, buf)
+		}
+	}
+}
+
+func TestMetricsTextfileInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mip.prom")
+	if err := ioutil.WriteFile(path, []byte("# HELP x\nmip_import_runs_total{importer=\"a\"} x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewMetrics().ReadTextfile(path); err == nil {
+		t.Error("ReadTextfile returned no error")
+	}
+}
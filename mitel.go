@@ -1,24 +1,26 @@
 package mip
 
 import (
-	"bufio"
 	"fmt"
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 	"github.com/tealeg/xlsx"
-	"io"
 	"log"
 	"regexp"
 	"strings"
 )
 
 type MitelImport struct {
-	name        string
-	cfg         *viper.Viper
-	summary     *ImportSummary
-	output      io.Writer
-	sheet       *xlsx.Sheet
-	startLine   int
-	column      *MitelColumns
+	name      string
+	cfg       *viper.Viper
+	summary   *ImportSummary
+	output    RecordWriter
+	sheet     *xlsx.Sheet
+	startLine int
+	column    *MitelColumns
+	filter    *Filter
+	// repair transforms the descriptions of the repairs
+	repair      Transforms
 	initialized bool
 }
 
@@ -28,6 +30,8 @@ type MitelColumns struct {
 	SellingPrice      *Column
 	RepairPrice       *Column
 	Description       *Column
+	// Family is the optional column with the product family
+	Family *Column
 }
 
 type Column struct {
@@ -35,7 +39,7 @@ type Column struct {
 	Regex *regexp.Regexp
 }
 
-func NewMitelImport(cfg *viper.Viper, output io.Writer) *MitelImport {
+func NewMitelImport(cfg *viper.Viper, output RecordWriter) *MitelImport {
 	return &MitelImport{
 		name:    "Mitel",
 		cfg:     cfg,
@@ -47,6 +51,7 @@ func NewMitelImport(cfg *viper.Viper, output io.Writer) *MitelImport {
 			&Column{},
 			&Column{},
 			&Column{},
+			nil,
 		},
 	}
 }
@@ -56,13 +61,28 @@ func (i *MitelImport) Name() string {
 }
 
 func (i *MitelImport) Init() error {
-	xlFile, err := xlsx.OpenFile(i.cfg.GetString("file"))
+	filter, err := NewFilter(i.cfg.Sub("filter"))
 	if err != nil {
-		return fmt.Errorf("failed to open xlsx", err)
+		return fmt.Errorf("filter: %w", err)
+	}
+	i.filter = filter
+
+	repair := []interface{}{map[string]interface{}{"prefix": "REPARATUR: "}}
+	if i.cfg.IsSet("repair_description_transforms") {
+		repair = cast.ToSlice(i.cfg.Get("repair_description_transforms"))
+	}
+	i.repair, err = NewTransforms(repair)
+	if err != nil {
+		return fmt.Errorf("repair_description_transforms%w", err)
+	}
+
+	xlFile, err := openXlsxFile(i.cfg.GetString("file"), i.cfg.GetString("file_member"), i.summary)
+	if err != nil {
+		return fmt.Errorf("failed to open xlsx: %w", err)
 	}
 
 	if len(xlFile.Sheets) < 1 {
-		return fmt.Errorf("no spreadsheetes in file")
+		return parseErrorf("no spreadsheetes in file")
 	}
 
 	i.sheet = xlFile.Sheets[0]
@@ -77,15 +97,25 @@ func (i *MitelImport) Init() error {
 }
 
 func (i *MitelImport) initColumns() error {
-	var err error
-	i.column.Id.Regex, err = regexp.Compile(i.cfg.GetString("column_pattern.id"))
-	i.column.SellingFactorName.Regex, err = regexp.Compile(i.cfg.GetString("column_pattern.selling_factor_name"))
-	i.column.SellingPrice.Regex, err = regexp.Compile(i.cfg.GetString("column_pattern.selling_price"))
-	i.column.RepairPrice.Regex, err = regexp.Compile(i.cfg.GetString("column_pattern.repair_price"))
-	i.column.Description.Regex, err = regexp.Compile(i.cfg.GetString("column_pattern.description"))
-
-	if err != nil {
-		return err
+	for key, column := range map[string]*Column{
+		"id":                  i.column.Id,
+		"selling_factor_name": i.column.SellingFactorName,
+		"selling_price":       i.column.SellingPrice,
+		"repair_price":        i.column.RepairPrice,
+		"description":         i.column.Description,
+	} {
+		var err error
+		column.Regex, err = regexp.Compile(i.cfg.GetString("column_pattern." + key))
+		if err != nil {
+			return configErrorf("invalid column_pattern.%s: %w", key, err)
+		}
+	}
+	if pattern := i.cfg.GetString("column_pattern.family"); pattern != "" {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return configErrorf("invalid column_pattern.family: %w", err)
+		}
+		i.column.Family = &Column{Regex: regex}
 	}
 
 	found := false
@@ -97,19 +127,23 @@ func (i *MitelImport) initColumns() error {
 		}
 	}
 	if !found {
-		return fmt.Errorf("could not find header line")
+		return parseErrorf("could not find header line")
 	}
 	return nil
 }
 
 func findColumns(i *MitelImport, row *xlsx.Row) bool {
-COLUMN:
-	for _, column := range []*Column{
+	columns := []*Column{
 		i.column.Id,
 		i.column.SellingFactorName,
 		i.column.SellingPrice,
 		i.column.RepairPrice,
-		i.column.Description} {
+		i.column.Description}
+	if i.column.Family != nil {
+		columns = append(columns, i.column.Family)
+	}
+COLUMN:
+	for _, column := range columns {
 
 		for index, cell := range row.Cells {
 			if column.Regex.FindStringIndex(cell.String()) != nil {
@@ -130,11 +164,20 @@ func (i *MitelImport) getSellingFactor(name string) (float64, error) {
 	}
 	factor, ok := value.(int)
 	if !ok {
-		return 0.0, fmt.Errorf("selling factor '%s' is not an integer\n", name)
+		return 0.0, configErrorf("selling factor '%s' is not an integer", name)
 	}
 	return float64(factor), nil
 }
 
+// family returns the product family of row or an empty string if there is no
+// family column.
+func (i *MitelImport) family(row *xlsx.Row) string {
+	if i.column.Family == nil || i.column.Family.Index >= len(row.Cells) {
+		return ""
+	}
+	return row.Cells[i.column.Family.Index].String()
+}
+
 func (i *MitelImport) Run() (*ImportSummary, error) {
 
 	if !i.initialized {
@@ -145,42 +188,60 @@ func (i *MitelImport) Run() (*ImportSummary, error) {
 	}
 
 	i.summary.Start()
-
-	outputBufWriter := bufio.NewWriter(i.output)
+	defer i.summary.Stop()
 
 	lineNumber := i.startLine + 1
 	for _, row := range i.sheet.Rows[i.startLine:] {
 		lineNumber++
 		if len(row.Cells)-1 < i.column.Description.Index {
 			log.Printf("skip line %d. only %d columns. line appears empty.\n", lineNumber, len(row.Cells))
+			i.summary.Reject(&Rejection{Ref: fmt.Sprintf("line %d", lineNumber), Reason: "empty line"})
 			continue
 		}
 		sellingPrice, err := row.Cells[i.column.SellingPrice.Index].Float()
 		if err != nil {
 			log.Printf("failed to read line %d: could not parse selling price '%s'\n", lineNumber, row.Cells[i.column.SellingPrice.Index])
+			i.summary.Reject(&Rejection{
+				Ref:    fmt.Sprintf("line %d", lineNumber),
+				Id:     row.Cells[i.column.Id.Index].String(),
+				Reason: "invalid selling price",
+				Detail: row.Cells[i.column.SellingPrice.Index].String(),
+			})
 			continue
 		}
 		sellingFactorName := strings.Trim(row.Cells[i.column.SellingFactorName.Index].String(), " ")
 		sellingFactorPercent, err := i.getSellingFactor(sellingFactorName)
 		if err != nil {
 			log.Printf("could not get selling factor '%s': '%s'. skip row %d\n", sellingFactorName, err, lineNumber)
+			i.summary.Reject(&Rejection{
+				Ref:    fmt.Sprintf("line %d", lineNumber),
+				Id:     row.Cells[i.column.Id.Index].String(),
+				Reason: "unknown selling factor",
+				Detail: sellingFactorName,
+			})
 			continue
 		}
 		sellingFactor := 100.0 / (100.0 - sellingFactorPercent)
+		price := NewMoney(sellingPrice)
 		r := &Record{
 			Id:             row.Cells[i.column.Id.Index].String(),
 			IdPrefix:       i.cfg.GetString("id_prefix"),
 			Description:    row.Cells[i.column.Description.Index].String(),
-			PurchasePrice:  sellingPrice / sellingFactor,
+			PurchasePrice:  price.Div(sellingFactor),
 			PurchaseFactor: i.cfg.GetFloat64("purchase_factor"),
 			SellingFactor:  sellingFactor,
-			SellingPrice:   sellingPrice,
+			SellingPrice:   price,
 			Category:       "Mitel",
 			CategoryNumber: i.cfg.GetString("category_number"),
 		}
+		// the id is the part number of Mitel
+		r.SetAttr(AttrManufacturer, "Mitel")
+		r.SetAttr(AttrMPN, r.Id)
+		r.SetAttr(AttrSupplierCategory, i.family(row))
 		i.summary.Articles++
-		_, err = outputBufWriter.WriteString(r.FormatLine())
-		if err != nil {
+		if reason, ignored := i.filter.Ignore(r); ignored {
+			i.summary.Ignore(reason)
+		} else if err := i.output.WriteRecord(r); err != nil {
 			return i.summary, err
 		}
 
@@ -191,29 +252,33 @@ func (i *MitelImport) Run() (*ImportSummary, error) {
 		repairPrice, err := row.Cells[i.column.RepairPrice.Index].Float()
 		if err != nil {
 			log.Printf("could not parse repair price on row %d. skip repair\n", lineNumber)
+			i.summary.Reject(&Rejection{
+				Ref:    fmt.Sprintf("line %d", lineNumber),
+				Id:     row.Cells[i.column.Id.Index].String(),
+				Reason: "invalid repair price",
+				Detail: row.Cells[i.column.RepairPrice.Index].String(),
+			})
 			continue
 		}
 		r = &Record{
 			Id:             row.Cells[i.column.Id.Index].String(),
 			IdPrefix:       "REP-",
-			Description:    "REPARATUR: " + row.Cells[i.column.Description.Index].String(),
-			PurchasePrice:  repairPrice,
+			Description:    i.repair.Apply(row.Cells[i.column.Description.Index].String()),
+			PurchasePrice:  NewMoney(repairPrice),
 			PurchaseFactor: i.cfg.GetFloat64("purchase_factor"),
 			SellingFactor:  i.cfg.GetFloat64("selling_repair_factor"),
-			SellingPrice:   repairPrice * i.cfg.GetFloat64("selling_repair_factor"),
+			SellingPrice:   NewMoney(repairPrice).Mul(i.cfg.GetFloat64("selling_repair_factor")),
 			Category:       "Mitel",
 			CategoryNumber: i.cfg.GetString("category_number"),
 		}
+		r.SetAttr(AttrSupplierCategory, i.family(row))
 		i.summary.Articles++
-		_, err = outputBufWriter.WriteString(r.FormatLine())
-		if err != nil {
+		if reason, ignored := i.filter.Ignore(r); ignored {
+			i.summary.Ignore(reason)
+		} else if err := i.output.WriteRecord(r); err != nil {
 			return i.summary, err
 		}
 	}
-	err := outputBufWriter.Flush()
-	if err != nil {
-		return i.summary, err
-	}
 
 	return i.summary, nil
 }
@@ -0,0 +1,214 @@
+package mip
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount with a fixed number of decimal places. Prices are
+// stored as Money, so that calculations like 100 / 1.25 * 1.25 result in
+// exactly 100 and are rounded the same way everywhere.
+type Money int64
+
+// MoneyDecimals is the number of decimal places of Money.
+const MoneyDecimals = 4
+
+const moneyScale = 10000
+
+// NewMoney returns the amount f rounded half-up to MoneyDecimals places.
+func NewMoney(f float64) Money {
+	return Money(math.Round(f * moneyScale))
+}
+
+// ParseMoney parses a decimal number like "-12.3456". Digits beyond
+// MoneyDecimals are rounded half-up. Numbers in other notations (e.g.
+// "1.2e3") are parsed as float. NaN, infinity and numbers which do not fit
+// into Money are rejected.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if m, ok := parseDecimal(s); ok {
+		return m, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.Abs(f) >= maxMoney {
+		return 0, fmt.Errorf("invalid amount '%s'", s)
+	}
+	return NewMoney(f), nil
+}
+
+// maxMoney is the limit of the amounts which fit into Money.
+const maxMoney = math.MaxInt64 / moneyScale
+
+func parseDecimal(s string) (Money, bool) {
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	if integer == "" && fraction == "" || len(integer) > 14 {
+		return 0, false
+	}
+	var v int64
+	for _, digits := range []string{integer, fraction} {
+		for _, c := range digits {
+			if c < '0' || c > '9' {
+				return 0, false
+			}
+		}
+	}
+	for _, c := range integer {
+		v = v*10 + int64(c-'0')
+	}
+	for n := 0; n < MoneyDecimals; n++ {
+		v *= 10
+		if n < len(fraction) {
+			v += int64(fraction[n] - '0')
+		}
+	}
+	if len(fraction) > MoneyDecimals && fraction[MoneyDecimals] >= '5' {
+		v++
+	}
+	if negative {
+		v = -v
+	}
+	return Money(v), true
+}
+
+// UnmarshalText parses the text of XML elements and attributes.
+func (m *Money) UnmarshalText(text []byte) error {
+	v, err := ParseMoney(string(text))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// MarshalJSON writes m as JSON number.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number, e.g. the float prices of older
+// databases.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	return m.UnmarshalText(data)
+}
+
+// Float64 returns m as float, e.g. to calculate percentages.
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+// Mul returns m multiplied by the factor f rounded half-up.
+func (m Money) Mul(f float64) Money {
+	return Money(math.Round(float64(m) * f))
+}
+
+// Div returns m divided by f rounded half-up. The result is 0 if f is 0.
+func (m Money) Div(f float64) Money {
+	if f == 0 {
+		return 0
+	}
+	return Money(math.Round(float64(m) / f))
+}
+
+// roundTo rounds m half-up (away from zero) to a multiple of step.
+func (m Money) roundTo(step Money) Money {
+	if step <= 1 {
+		return m
+	}
+	if m < 0 {
+		return -(-m).roundTo(step)
+	}
+	return (m + step/2) / step * step
+}
+
+// Format formats m with precision decimal places rounded half-up.
+func (m Money) Format(precision int) string {
+	if precision < MoneyDecimals {
+		m = m.roundTo(Money(math.Pow10(MoneyDecimals - precision)))
+	}
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	integer := strconv.FormatInt(int64(m/moneyScale), 10)
+	fraction := strconv.FormatInt(int64(m%moneyScale)+moneyScale, 10)[1:]
+	if precision <= MoneyDecimals {
+		fraction = fraction[:precision]
+	} else {
+		fraction += strings.Repeat("0", precision-MoneyDecimals)
+	}
+	if fraction == "" {
+		return sign + integer
+	}
+	return sign + integer + "." + fraction
+}
+
+// String formats m with at least two decimal places, e.g. "19.90" or
+// "144.3599".
+func (m Money) String() string {
+	s := strings.TrimRight(m.Format(MoneyDecimals), "0")
+	if i := strings.IndexByte(s, '.'); len(s)-i-1 < 2 {
+		s += strings.Repeat("0", 2-(len(s)-i-1))
+	}
+	return s
+}
+
+const (
+	// RoundNone keeps the prices as they are. The export rounds them
+	// half-up to its price precision.
+	RoundNone = ""
+	// RoundHalfUp rounds to centimes, 0.005 is rounded up.
+	RoundHalfUp = "half_up"
+	// RoundSwiss rounds to 0.05 (Rappenrundung), 0.025 is rounded up.
+	RoundSwiss = "swiss"
+)
+
+// Rounding rounds prices with one of the rounding modes.
+type Rounding string
+
+// NewRounding returns the rounding mode with the name.
+func NewRounding(mode string) (Rounding, error) {
+	switch mode {
+	case RoundNone, RoundHalfUp, RoundSwiss:
+		return Rounding(mode), nil
+	}
+	return "", configErrorf("unknown rounding '%s'", mode)
+}
+
+// Round rounds m.
+func (r Rounding) Round(m Money) Money {
+	switch r {
+	case RoundHalfUp:
+		return m.roundTo(moneyScale / 100)
+	case RoundSwiss:
+		return m.roundTo(moneyScale / 20)
+	}
+	return m
+}
+
+// PriceRounding is a RecordWriter which rounds the prices of the records
+// before it writes them to the next writer.
+type PriceRounding struct {
+	purchase Rounding
+	selling  Rounding
+	w        RecordWriter
+}
+
+func NewPriceRounding(purchase, selling Rounding, w RecordWriter) *PriceRounding {
+	return &PriceRounding{purchase: purchase, selling: selling, w: w}
+}
+
+func (p *PriceRounding) WriteRecord(r *Record) error {
+	r.convertPrices(p.purchase.Round, p.selling.Round)
+	return p.w.WriteRecord(r)
+}
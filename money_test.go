@@ -0,0 +1,162 @@
+package mip
+
+import "testing"
+
+func TestParseDecimal(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want Money
+		ok   bool
+	}{
+		{"12.3456", 123456, true},
+		{"-12.3456", -123456, true},
+		{"+1", 10000, true},
+		{"0", 0, true},
+		{".5", 5000, true},
+		{"5.", 50000, true},
+		{"1.23454", 12345, true},
+		{"1.23455", 12346, true},
+		{"0.00005", 1, true},
+		{"-0.00005", -1, true},
+		{"19.99995", 200000, true},
+		{"12345678901234", 123456789012340000, true},
+		{"", 0, false},
+		{".", 0, false},
+		{"-", 0, false},
+		{"--1", 0, false},
+		{"1e3", 0, false},
+		{"1,5", 0, false},
+		{"1.2.3", 0, false},
+		{" 1", 0, false},
+		{"123456789012345", 0, false},
+	} {
+		got, ok := parseDecimal(test.in)
+		if got != test.want || ok != test.ok {
+			t.Errorf("parseDecimal(%q) = %d, %t, want %d, %t", test.in, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want Money
+	}{
+		{"19.90", 199000},
+		{" 1.5 ", 15000},
+		{"1.2e3", 12000000},
+		{"-0.5", -5000},
+	} {
+		got, err := ParseMoney(test.in)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %s", test.in, err)
+		} else if got != test.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", test.in, got, test.want)
+		}
+	}
+	for _, in := range []string{"", "abc", "1,5", "NaN", "nan", "Inf", "-Inf", "+Infinity", "1e300", "-1e20"} {
+		if _, err := ParseMoney(in); err == nil {
+			t.Errorf("ParseMoney(%q) returned no error", in)
+		}
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	for _, test := range []struct {
+		m         Money
+		precision int
+		want      string
+	}{
+		{123456, 2, "12.35"},
+		{123449, 2, "12.34"},
+		{123450, 2, "12.35"},
+		{-123450, 2, "-12.35"},
+		{5, 2, "0.00"},
+		{-5, 2, "0.00"},
+		{-50, 2, "-0.01"},
+		{123456, 0, "12"},
+		{125000, 0, "13"},
+		{123456, 4, "12.3456"},
+		{123456, 6, "12.345600"},
+		{50, 4, "0.0050"},
+		{0, 2, "0.00"},
+	} {
+		if got := test.m.Format(test.precision); got != test.want {
+			t.Errorf("Money(%d).Format(%d) = %s, want %s", test.m, test.precision, got, test.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	for _, test := range []struct {
+		m    Money
+		want string
+	}{
+		{199000, "19.90"},
+		{1443599, "144.3599"},
+		{10000, "1.00"},
+		{0, "0.00"},
+		{-15000, "-1.50"},
+		{123, "0.0123"},
+	} {
+		if got := test.m.String(); got != test.want {
+			t.Errorf("Money(%d).String() = %s, want %s", test.m, got, test.want)
+		}
+	}
+}
+
+func TestMoneyRoundTo(t *testing.T) {
+	for _, test := range []struct {
+		m, step, want Money
+	}{
+		{1234, 500, 1000},
+		{1249, 500, 1000},
+		{1250, 500, 1500},
+		{-1250, 500, -1500},
+		{-1249, 500, -1000},
+		{1234, 1, 1234},
+		{1234, 0, 1234},
+	} {
+		if got := test.m.roundTo(test.step); got != test.want {
+			t.Errorf("Money(%d).roundTo(%d) = %d, want %d", test.m, test.step, got, test.want)
+		}
+	}
+}
+
+func TestRounding(t *testing.T) {
+	for _, test := range []struct {
+		mode string
+		m    Money
+		want Money
+	}{
+		{RoundNone, 10049, 10049},
+		{RoundHalfUp, 10049, 10000},
+		{RoundHalfUp, 10050, 10100},
+		{RoundSwiss, 10240, 10000},
+		{RoundSwiss, 10250, 10500},
+		{RoundSwiss, -10250, -10500},
+	} {
+		r, err := NewRounding(test.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Round(test.m); got != test.want {
+			t.Errorf("Rounding(%q).Round(%d) = %d, want %d", test.mode, test.m, got, test.want)
+		}
+	}
+	if _, err := NewRounding("down"); err == nil {
+		t.Error("NewRounding(\"down\") returned no error")
+	}
+}
+
+func TestMoneyMulDiv(t *testing.T) {
+	if got := NewMoney(0.1 + 0.2); got != 3000 {
+		t.Errorf("NewMoney(0.1 + 0.2) = %d, want 3000", got)
+	}
+	if got := Money(1000000).Div(1.25).Mul(1.25); got != 1000000 {
+		t.Errorf("100 / 1.25 * 1.25 = %d, want 1000000", got)
+	}
+	if got := Money(1000000).Div(0); got != 0 {
+		t.Errorf("Div(0) = %d, want 0", got)
+	}
+}
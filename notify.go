@@ -0,0 +1,366 @@
+package mip
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// rules when to send a notification
+const (
+	NotifyAlways    = "always"
+	NotifyFailure   = "failure"
+	NotifyThreshold = "threshold"
+)
+
+// Notifier sends a notification about a finished run (e.g. an email). When a
+// notification is sent is decided by the rule of the notifier:
+//
+//	always      after each run
+//	failure     if an importer failed
+//	threshold   if an importer failed or skipped more articles than allowed
+//	            by max_skipped or max_skipped_percent
+type Notifier struct {
+	when              string
+	maxSkipped        int
+	maxSkippedPercent float64
+	sender            sender
+}
+
+// sender sends the notification about the report rep. reasons explains why
+// the notification is sent.
+type sender interface {
+	send(rep *Report, reasons []string) error
+	String() string
+}
+
+// NewNotifier returns the notifier configured in cfg:
+//
+//	type                  email or webhook
+//	when                  always, failure (default) or threshold
+//	max_skipped           threshold of skipped articles per importer
+//	max_skipped_percent   threshold of skipped articles in percent of the
+//	                      articles per importer
+//
+// email:
+//
+//	smtp_address   host:port of the SMTP server
+//	user           user to authenticate, no authentication if empty
+//	password       password to authenticate
+//	from           sender address
+//	to             list of recipient addresses
+//	subject        prefix of the subject (default "mip")
+//
+// webhook:
+//
+//	url       URL the report is posted to as JSON
+//	headers   additional request headers, e.g. for authentication
+func NewNotifier(cfg *viper.Viper) (*Notifier, error) {
+	n := &Notifier{
+		when:              cfg.GetString("when"),
+		maxSkipped:        cfg.GetInt("max_skipped"),
+		maxSkippedPercent: cfg.GetFloat64("max_skipped_percent"),
+	}
+	if n.when == "" {
+		n.when = NotifyFailure
+	}
+	if n.when != NotifyAlways && n.when != NotifyFailure && n.when != NotifyThreshold {
+		return nil, configErrorf("unknown notification rule '%s'", n.when)
+	}
+	if n.when == NotifyThreshold && n.maxSkipped == 0 && n.maxSkippedPercent == 0 {
+		return nil, configErrorf("notification rule threshold requires max_skipped or max_skipped_percent")
+	}
+
+	switch cfg.GetString("type") {
+	case "email":
+		s := &emailSender{
+			addr:     cfg.GetString("smtp_address"),
+			user:     cfg.GetString("user"),
+			password: cfg.GetString("password"),
+			from:     cfg.GetString("from"),
+			to:       cfg.GetStringSlice("to"),
+			subject:  cfg.GetString("subject"),
+		}
+		if s.addr == "" || s.from == "" || len(s.to) == 0 {
+			return nil, configErrorf("email notification: smtp_address, from and to are required")
+		}
+		if s.subject == "" {
+			s.subject = "mip"
+		}
+		n.sender = s
+	case "webhook":
+		s := &webhookSender{
+			url:     cfg.GetString("url"),
+			headers: cfg.GetStringMapString("headers"),
+		}
+		if s.url == "" {
+			return nil, configErrorf("webhook notification: url is required")
+		}
+		n.sender = s
+	default:
+		return nil, configErrorf("unknown notification type '%s'", cfg.GetString("type"))
+	}
+	return n, nil
+}
+
+func (n *Notifier) String() string {
+	return n.sender.String()
+}
+
+// reasons returns why a notification about rep has to be sent according to
+// the rule of n. If none has to be sent it returns nil.
+func (n *Notifier) reasons(rep *Report) []string {
+	var reasons []string
+	for _, ir := range rep.Importers {
+		if ir.Status != StatusOK {
+			reasons = append(reasons, fmt.Sprintf("%s failed", ir.Name))
+			continue
+		}
+		if n.when != NotifyThreshold {
+			continue
+		}
+		if n.maxSkipped > 0 && ir.Skipped > n.maxSkipped {
+			reasons = append(reasons, fmt.Sprintf("%s skipped %d articles (max. %d)", ir.Name, ir.Skipped, n.maxSkipped))
+		} else if n.maxSkippedPercent > 0 && ir.Articles > 0 {
+			percent := float64(ir.Skipped) * 100 / float64(ir.Articles)
+			if percent > n.maxSkippedPercent {
+				reasons = append(reasons, fmt.Sprintf("%s skipped %.1f%% of the articles (max. %g%%)", ir.Name, percent, n.maxSkippedPercent))
+			}
+		}
+	}
+	if rep.Status != StatusOK && len(reasons) == 0 {
+		reasons = append(reasons, rep.Error)
+	}
+	if n.when == NotifyAlways && len(reasons) == 0 {
+		reasons = append(reasons, "run finished")
+	}
+	return reasons
+}
+
+// Notify sends a notification about rep if the rule of n requires it.
+func (n *Notifier) Notify(rep *Report) error {
+	reasons := n.reasons(rep)
+	if len(reasons) == 0 {
+		return nil
+	}
+	if err := n.sender.send(rep, reasons); err != nil {
+		return fmt.Errorf("failed to notify %s: %w", n, err)
+	}
+	return nil
+}
+
+// reportText returns a human-readable summary of rep.
+func reportText(rep *Report, reasons []string) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "status: %s\n", rep.Status)
+	if rep.Error != "" {
+		fmt.Fprintf(buf, "error: %s\n", rep.Error)
+	}
+	fmt.Fprintf(buf, "started: %s\n", rep.Start.Format(time.RFC1123))
+	fmt.Fprintf(buf, "duration: %s\n", time.Duration(rep.Duration*float64(time.Second)).Round(time.Second))
+	fmt.Fprintln(buf)
+	for _, reason := range reasons {
+		fmt.Fprintf(buf, "- %s\n", reason)
+	}
+	for _, ir := range rep.Importers {
+		fmt.Fprintf(buf, "\n%s: %s\n", ir.Name, ir.Status)
+		if ir.Error != "" {
+			fmt.Fprintf(buf, "  error: %s\n", ir.Error)
+		}
+		fmt.Fprintf(buf, "  articles: %d\n", ir.Articles)
+		fmt.Fprintf(buf, "  ignored: %d\n", ir.Ignored)
+		writeReasons(buf, ir.IgnoredByReason)
+		fmt.Fprintf(buf, "  skipped: %d\n", ir.Skipped)
+		writeReasons(buf, ir.SkippedByReason)
+		if len(ir.UnmappedCategories) > 0 {
+			fmt.Fprintf(buf, "  unmapped categories: %d\n", len(ir.UnmappedCategories))
+			writeReasons(buf, ir.UnmappedCategories)
+		}
+	}
+	return buf.String()
+}
+
+func writeReasons(w io.Writer, counts map[string]int) {
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "    %s: %d\n", reason, counts[reason])
+	}
+}
+
+// rejectionsCSV returns the rejections of all importers of rep as CSV.
+func rejectionsCSV(rep *Report) []byte {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"importer", "position", "id", "reason", "value"})
+	for _, ir := range rep.Importers {
+		for _, r := range ir.Rejections {
+			w.Write([]string{ir.Name, r.Ref, r.Id, r.Reason, r.Detail})
+		}
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+func countRejections(rep *Report) int {
+	n := 0
+	for _, ir := range rep.Importers {
+		n += len(ir.Rejections)
+	}
+	return n
+}
+
+type emailSender struct {
+	addr     string
+	user     string
+	password string
+	from     string
+	to       []string
+	subject  string
+}
+
+func (s *emailSender) String() string {
+	return "smtp://" + s.addr
+}
+
+func (s *emailSender) send(rep *Report, reasons []string) error {
+	msg, err := s.message(rep, reasons)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.user != "" {
+		host := s.addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.user, s.password, host)
+	}
+	return smtp.SendMail(s.addr, auth, s.from, s.to, msg)
+}
+
+// message returns the email with the summary as text and the rejections as
+// CSV attachment.
+func (s *emailSender) message(rep *Report, reasons []string) ([]byte, error) {
+	hostname, _ := os.Hostname()
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "From: %s\r\n", s.from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(buf, "Subject: %s: import %s on %s\r\n", s.subject, rep.Status, hostname)
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qw := quotedprintable.NewWriter(part)
+	io.WriteString(qw, reportText(rep, reasons))
+	if err := qw.Close(); err != nil {
+		return nil, err
+	}
+
+	if countRejections(rep) > 0 {
+		part, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/csv; charset=utf-8"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {`attachment; filename="rejections.csv"`},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(rejectionsCSV(rep))
+		// lines of an email must not be longer than 998 characters
+		for len(encoded) > 76 {
+			io.WriteString(part, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		io.WriteString(part, encoded+"\r\n")
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type webhookSender struct {
+	url     string
+	headers map[string]string
+}
+
+func (s *webhookSender) String() string {
+	return s.url
+}
+
+// webhookImporter is the report of an importer including its rejections.
+type webhookImporter struct {
+	*ImporterReport
+	Rejections []*Rejection `json:"rejections"`
+}
+
+// send posts the report including the rejections as JSON.
+func (s *webhookSender) send(rep *Report, reasons []string) error {
+	payload := struct {
+		*Report
+		Reasons   []string           `json:"reasons"`
+		Importers []*webhookImporter `json:"importers"`
+	}{
+		Report:    rep,
+		Reasons:   reasons,
+		Importers: []*webhookImporter{},
+	}
+	for _, ir := range rep.Importers {
+		rejections := ir.Rejections
+		if rejections == nil {
+			rejections = []*Rejection{}
+		}
+		payload.Importers = append(payload.Importers, &webhookImporter{ir, rejections})
+	}
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+	client := &http.Client{Timeout: HTTP_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package mip
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// smtpMessage is a message received by the SMTP stand-in.
+type smtpMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// smtpStandIn accepts a single SMTP session on ln and sends the received
+// message to messages.
+func smtpStandIn(ln net.Listener, messages chan<- *smtpMessage) {
+	conn, err := ln.Accept()
+	if err != nil {
+		close(messages)
+		return
+	}
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	msg := &smtpMessage{}
+	c.PrintfLine("220 localhost stand-in")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			close(messages)
+			return
+		}
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			c.PrintfLine("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			c.PrintfLine("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			c.PrintfLine("250 OK")
+		case cmd == "DATA":
+			c.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			msg.data, err = c.ReadDotBytes()
+			if err != nil {
+				close(messages)
+				return
+			}
+			c.PrintfLine("250 OK")
+		case cmd == "QUIT":
+			c.PrintfLine("221 bye")
+			messages <- msg
+			close(messages)
+			return
+		default:
+			c.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func failedReport() *Report {
+	rep := NewReport()
+	s := NewImportSummary()
+	s.Articles = 10
+	s.Reject(&Rejection{Ref: "line 3", Id: "S1", Reason: "invalid price", Detail: "abc"})
+	rep.Add("Suprag", s, fmt.Errorf("failed to write output"))
+	rep.Finish(fmt.Errorf("Suprag failed"))
+	return rep
+}
+
+func TestEmailNotification(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	messages := make(chan *smtpMessage, 1)
+	go smtpStandIn(ln, messages)
+
+	cfg := viper.New()
+	cfg.Set("type", "email")
+	cfg.Set("smtp_address", ln.Addr().String())
+	cfg.Set("from", "mip@example.com")
+	cfg.Set("to", []string{"a@example.com", "b@example.com"})
+	n, err := NewNotifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(failedReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-messages
+	if msg == nil {
+		t.Fatal("no message received")
+	}
+	if msg.from != "mip@example.com" || strings.Join(msg.to, ",") != "a@example.com,b@example.com" {
+		t.Errorf("message from %s to %v", msg.from, msg.to)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject := m.Header.Get("Subject"); !strings.HasPrefix(subject, "mip: import failed on ") {
+		t.Errorf("subject = %q", subject)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type %q: %v", m.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+
+	// the quoted-printable encoding is removed by the multipart reader
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := ioutil.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"status: failed\n", "- Suprag failed\n", "Suprag: failed\n", "  skipped: 1\n    invalid price: 1\n"} {
+		if !strings.Contains(string(text), want) {
+			t.Errorf("text does not contain %q:\n%s", want, text)
+		}
+	}
+
+	part, err = mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename := part.FileName(); filename != "rejections.csv" {
+		t.Errorf("attachment file name = %q", filename)
+	}
+	attachment, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "importer,position,id,reason,value\nSuprag,line 3,S1,invalid price,abc\n"
+	if string(attachment) != want {
+		t.Errorf("attachment = %q, want %q", attachment, want)
+	}
+}
+
+func TestEmailNotificationUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := viper.New()
+	cfg.Set("type", "email")
+	cfg.Set("smtp_address", addr)
+	cfg.Set("from", "mip@example.com")
+	cfg.Set("to", []string{"a@example.com"})
+	n, err := NewNotifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(failedReport()); err == nil {
+		t.Error("Notify returned no error")
+	}
+}
+
+func TestNotifierReasons(t *testing.T) {
+	ok := func(articles, skipped int) *Report {
+		rep := NewReport()
+		s := NewImportSummary()
+		s.Articles = articles
+		s.Skipped = skipped
+		rep.Add("Alltron", s, nil)
+		rep.Finish(nil)
+		return rep
+	}
+	for _, test := range []struct {
+		settings map[string]interface{}
+		rep      *Report
+		want     []string
+	}{
+		{map[string]interface{}{}, ok(10, 5), nil},
+		{map[string]interface{}{}, failedReport(), []string{"Suprag failed"}},
+		{map[string]interface{}{"when": "always"}, ok(10, 5), []string{"run finished"}},
+		{map[string]interface{}{"when": "threshold", "max_skipped": 5}, ok(10, 5), nil},
+		{map[string]interface{}{"when": "threshold", "max_skipped": 4}, ok(10, 5), []string{"Alltron skipped 5 articles (max. 4)"}},
+		{map[string]interface{}{"when": "threshold", "max_skipped_percent": 50}, ok(10, 5), nil},
+		{map[string]interface{}{"when": "threshold", "max_skipped_percent": 40}, ok(10, 5), []string{"Alltron skipped 50.0% of the articles (max. 40%)"}},
+	} {
+		cfg := viper.New()
+		cfg.Set("type", "webhook")
+		cfg.Set("url", "http://localhost/")
+		for key, value := range test.settings {
+			cfg.Set(key, value)
+		}
+		n, err := NewNotifier(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := n.reasons(test.rep)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("reasons with %v = %q, want %q", test.settings, got, test.want)
+		}
+	}
+}
+
+func TestNewNotifierErrors(t *testing.T) {
+	for _, settings := range []map[string]interface{}{
+		{"type": "pigeon"},
+		{"type": "email", "from": "mip@example.com", "to": []string{"a@example.com"}},
+		{"type": "email", "smtp_address": "localhost:25", "to": []string{"a@example.com"}},
+		{"type": "email", "smtp_address": "localhost:25", "from": "mip@example.com"},
+		{"type": "webhook"},
+		{"type": "webhook", "url": "http://localhost/", "when": "sometimes"},
+		{"type": "webhook", "url": "http://localhost/", "when": "threshold"},
+	} {
+		cfg := viper.New()
+		for key, value := range settings {
+			cfg.Set(key, value)
+		}
+		if _, err := NewNotifier(cfg); err == nil {
+			t.Errorf("NewNotifier(%v) returned no error", settings)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package mip
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AttrPackageSize is the attribute with the number of units in a package of
+// the article.
+const AttrPackageSize = "package_size"
+
+// PriceTier is the price of an article from a minimal order quantity on.
+type PriceTier struct {
+	Quantity      int
+	PurchasePrice Money
+	SellingPrice  Money
+}
+
+// SortPriceTiers sorts the tiers by quantity.
+func SortPriceTiers(tiers []PriceTier) {
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].Quantity < tiers[j].Quantity })
+}
+
+// convertPrices replaces the purchase and selling prices of r and of its
+// price tiers.
+func (r *Record) convertPrices(purchase, selling func(Money) Money) {
+	r.PurchasePrice = purchase(r.PurchasePrice)
+	r.SellingPrice = selling(r.SellingPrice)
+	for n := range r.PriceTiers {
+		t := &r.PriceTiers[n]
+		t.PurchasePrice = purchase(t.PurchasePrice)
+		t.SellingPrice = selling(t.SellingPrice)
+	}
+}
+
+// tier returns the price tier n (starting at 1) of r, nil if r has less
+// tiers.
+func (r *Record) tier(n int) *PriceTier {
+	if n < 1 || n > len(r.PriceTiers) {
+		return nil
+	}
+	return &r.PriceTiers[n-1]
+}
+
+// setTier returns the price tier n (starting at 1) of r. Missing tiers are
+// added.
+func (r *Record) setTier(n int) *PriceTier {
+	for len(r.PriceTiers) < n {
+		r.PriceTiers = append(r.PriceTiers, PriceTier{})
+	}
+	return &r.PriceTiers[n-1]
+}
+
+// the columns of a price tier
+const (
+	tierQuantity      = "quantity"
+	tierPurchasePrice = "purchase_price"
+	tierSellingPrice  = "selling_price"
+)
+
+var (
+	tierColumnName  = regexp.MustCompile(`^tier_(quantity|purchase_price|selling_price)_([1-9][0-9]?)$`)
+	tierColumnsName = regexp.MustCompile(`^price_tiers_([1-9][0-9]?)$`)
+	tierTitles      = map[string]string{
+		tierQuantity:      "Staffelmenge",
+		tierPurchasePrice: "Staffel-Einkaufspreis",
+		tierSellingPrice:  "Staffel-Verkaufspreis",
+	}
+)
+
+// tierColumn returns the column field (quantity, purchase_price or
+// selling_price) of the price tier n. The column is empty if the record has
+// less tiers.
+func tierColumn(field string, n int) ExportColumn {
+	name := fmt.Sprintf("tier_%s_%d", field, n)
+	title := fmt.Sprintf("%s %d", tierTitles[field], n)
+	return ExportColumn{name, title, func(r *Record, f *CSVFormat) string {
+		t := r.tier(n)
+		if t == nil {
+			return ""
+		}
+		switch field {
+		case tierQuantity:
+			return strconv.Itoa(t.Quantity)
+		case tierPurchasePrice:
+			return f.price(t.PurchasePrice)
+		}
+		return f.price(t.SellingPrice)
+	}}
+}
+
+// tierColumns returns the columns of the price tiers with the name, nil if
+// name is no price tier column. Names are:
+//
+//	tier_quantity_<n>         minimal quantity of tier n
+//	tier_purchase_price_<n>   purchase price of tier n
+//	tier_selling_price_<n>    selling price of tier n
+//	price_tiers_<n>           quantity, purchase and selling price of the
+//	                          tiers 1 to n
+func tierColumns(name string) []ExportColumn {
+	if m := tierColumnName.FindStringSubmatch(name); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		return []ExportColumn{tierColumn(m[1], n)}
+	}
+	if m := tierColumnsName.FindStringSubmatch(name); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		var columns []ExportColumn
+		for i := 1; i <= n; i++ {
+			for _, field := range []string{tierQuantity, tierPurchasePrice, tierSellingPrice} {
+				columns = append(columns, tierColumn(field, i))
+			}
+		}
+		return columns
+	}
+	return nil
+}
+
+// tierByTitle returns the field and the number of the price tier column with
+// title. ok is false if title is no price tier column.
+func tierByTitle(title string) (field string, n int, ok bool) {
+	for field, prefix := range tierTitles {
+		if !strings.HasPrefix(title, prefix+" ") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(title, prefix+" "))
+		if err != nil || n < 1 || n > 99 {
+			continue
+		}
+		return field, n, true
+	}
+	return "", 0, false
+}
@@ -0,0 +1,149 @@
+package mip
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusPartial = "partial"
+	StatusFailed  = "failed"
+)
+
+// Report is the machine-readable summary of one mip invocation.
+type Report struct {
+	Start     time.Time         `json:"start"`
+	End       time.Time         `json:"end"`
+	Duration  float64           `json:"duration_seconds"`
+	Status    string            `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	Importers []*ImporterReport `json:"importers"`
+}
+
+// ImporterReport contains the result of one importer.
+type ImporterReport struct {
+	Name            string          `json:"name"`
+	Start           time.Time       `json:"start"`
+	End             time.Time       `json:"end"`
+	Duration        float64         `json:"duration_seconds"`
+	Articles        int             `json:"articles"`
+	Ignored         int             `json:"ignored"`
+	IgnoredByReason map[string]int  `json:"ignored_by_reason"`
+	Skipped         int             `json:"skipped"`
+	SkippedByReason map[string]int  `json:"skipped_by_reason"`
+	BytesDownloaded int64           `json:"bytes_downloaded"`
+	Sources         []*SourceReport `json:"sources"`
+	Status          string          `json:"status"`
+	Error           string          `json:"error,omitempty"`
+	ErrorKind       string          `json:"error_kind,omitempty"`
+	// number of articles by supplier category without category mapping
+	UnmappedCategories map[string]int `json:"unmapped_categories,omitempty"`
+	// the rate the prices were converted with to the currency of the export
+	ExchangeRate *ExchangeRate `json:"exchange_rate,omitempty"`
+	// the rejections are not part of the JSON report as there can be many
+	Rejections []*Rejection `json:"-"`
+}
+
+// SourceReport describes an input of an importer.
+type SourceReport struct {
+	Name     string  `json:"name"`
+	Bytes    int64   `json:"bytes"`
+	SHA256   string  `json:"sha256"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+func NewReport() *Report {
+	return &Report{
+		Start:     time.Now(),
+		Importers: []*ImporterReport{},
+	}
+}
+
+// Add adds the result of an importer to the report. s may be nil if the
+// importer failed before it produced a summary.
+func (r *Report) Add(name string, s *ImportSummary, err error) *ImporterReport {
+	ir := &ImporterReport{
+		Name:            name,
+		Status:          StatusOK,
+		IgnoredByReason: map[string]int{},
+		SkippedByReason: map[string]int{},
+		Sources:         []*SourceReport{},
+	}
+	if s != nil {
+		ir.Start = s.start
+		ir.End = s.end
+		ir.Duration = s.Duration().Seconds()
+		ir.Articles = s.Articles
+		ir.Ignored = s.Ignored
+		ir.IgnoredByReason = s.IgnoredByReason
+		ir.Skipped = s.Skipped
+		ir.SkippedByReason = s.SkippedByReason
+		ir.Rejections = s.Rejections
+		ir.UnmappedCategories = s.UnmappedCategories
+		ir.ExchangeRate = s.ExchangeRate
+		ir.BytesDownloaded = s.BytesDownloaded()
+		for _, src := range s.Sources {
+			ir.Sources = append(ir.Sources, &SourceReport{
+				Name:     src.Name,
+				Bytes:    src.Bytes,
+				SHA256:   src.Fingerprint(),
+				Duration: src.Duration.Seconds(),
+			})
+		}
+	}
+	if err != nil {
+		ir.Status = StatusFailed
+		ir.Error = err.Error()
+		ir.ErrorKind = KindOf(err).String()
+	}
+	r.Importers = append(r.Importers, ir)
+	return ir
+}
+
+// Finish sets the end time and the final status of the report. err is the
+// overall error of the invocation.
+func (r *Report) Finish(err error) {
+	r.End = time.Now()
+	r.Duration = r.End.Sub(r.Start).Seconds()
+	if err == nil {
+		r.Status = StatusOK
+		return
+	}
+	r.Error = err.Error()
+	r.Status = StatusFailed
+	for _, ir := range r.Importers {
+		if ir.Status == StatusOK {
+			r.Status = StatusPartial
+			return
+		}
+	}
+}
+
+// WriteFile writes the report as JSON to path. The file is replaced
+// atomically, so a monitoring system never reads a partial report.
+func (r *Report) WriteFile(path string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return outputErrorf("failed to write report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(content, '\n'))
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return outputErrorf("failed to write report: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return outputErrorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package mip
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"time"
+)
+
+// Source describes an input of an import. It is filled while the input is
+// read, so Bytes and Fingerprint only cover the whole input once it has been
+// read completely.
+type Source struct {
+	Name     string
+	Bytes    int64
+	Duration time.Duration
+	start    time.Time
+	hash     hash.Hash
+}
+
+// Fingerprint returns the hex encoded SHA-256 of the bytes read so far.
+func (s *Source) Fingerprint() string {
+	return hex.EncodeToString(s.hash.Sum(nil))
+}
+
+type sourceReader struct {
+	r   io.Reader
+	src *Source
+}
+
+func (sr *sourceReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.src.Bytes += int64(n)
+	sr.src.hash.Write(p[:n])
+	sr.src.Duration = time.Since(sr.src.start)
+	return n, err
+}
+
+// trackSource adds a Source with the given name to the summary and returns a
+// reader which records everything read from r in it.
+func (ps *ImportSummary) trackSource(name string, r io.Reader) io.Reader {
+	src := &Source{
+		Name:  name,
+		start: time.Now(),
+		hash:  sha256.New(),
+	}
+	ps.Sources = append(ps.Sources, src)
+	return &sourceReader{r: r, src: src}
+}
@@ -1,10 +1,9 @@
 package mip
 
 import (
-	"archive/zip"
-	"bufio"
 	"bytes"
 	"fmt"
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 	"github.com/tealeg/xlsx"
 	"io"
@@ -20,11 +19,12 @@ type SupragImport struct {
 	name        string
 	cfg         *viper.Viper
 	summary     *ImportSummary
-	output      io.Writer
+	output      RecordWriter
+	filter      *Filter
 	initialized bool
 }
 
-func NewSupragImport(cfg *viper.Viper, output io.Writer) *SupragImport {
+func NewSupragImport(cfg *viper.Viper, output RecordWriter) *SupragImport {
 	return &SupragImport{
 		name:    "Suprag",
 		cfg:     cfg,
@@ -38,6 +38,16 @@ func (i *SupragImport) Name() string {
 }
 
 func (i *SupragImport) Init() error {
+	filter, err := NewFilter(i.cfg.Sub("filter"))
+	if err != nil {
+		return fmt.Errorf("filter: %w", err)
+	}
+	// the list of ignored values from before the filter
+	if i.cfg.IsSet("ignored_manufacturers") {
+		log.Println("ignored_manufacturers is deprecated, use filter.exclude")
+	}
+	filter.ExcludeValues(AttrManufacturer, i.cfg.GetStringSlice("ignored_manufacturers"), "ignored manufacturer")
+	i.filter = filter
 	i.initialized = true
 	return nil
 }
@@ -46,16 +56,16 @@ func (i *SupragImport) getXlsxFile() (*xlsx.File, error) {
 	rawUrl := i.cfg.GetString("file")
 	url, err := url.Parse(rawUrl)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse url:", rawUrl)
+		return nil, configErrorf("failed to parse url '%s': %w", rawUrl, err)
 	}
 
 	if url.Scheme == "" {
 		log.Println("open local file")
-		return xlsx.OpenFile(rawUrl)
+		return openXlsxFile(rawUrl, i.cfg.GetString("file_member"), i.summary)
 	}
 
-	if url.Scheme != "http" || url.Scheme == "https" {
-		return nil, fmt.Errorf("scheme unsupported:", url.Scheme)
+	if url.Scheme != "http" && url.Scheme != "https" {
+		return nil, configErrorf("scheme unsupported: %s", url.Scheme)
 	}
 
 	maxFileSize := i.cfg.GetInt64("max_download_file_size")
@@ -66,47 +76,43 @@ func (i *SupragImport) getXlsxFile() (*xlsx.File, error) {
 		filePath := filepath.Join(i.cfg.GetString("save_dir"), path.Base(rawUrl))
 		saveFile, err := os.Create(filePath)
 		if err != nil {
-			return nil, err
+			return nil, outputErrorf("failed to save file: %w", err)
 		}
-		outputWriter = io.MultiWriter(bufio.NewWriter(saveFile), &content)
+		defer saveFile.Close()
+		outputWriter = io.MultiWriter(saveFile, &content)
 	} else {
 		outputWriter = &content
 	}
 
 	resp, err := http.Get(rawUrl)
 	if err != nil {
-		return nil, err
+		return nil, sourceErrorf("failed to download file: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to download file: ", resp.Status)
+		return nil, sourceErrorf("failed to download file: %s", resp.Status)
 	}
 
 	if resp.ContentLength > maxFileSize {
-		return nil, fmt.Errorf("file is to big")
+		return nil, sourceErrorf("file is to big")
 	}
 
 	log.Println("downloading file", rawUrl)
-	_, err = io.Copy(outputWriter, io.LimitReader(resp.Body, maxFileSize))
+	body := i.summary.trackSource(rawUrl, resp.Body)
+	_, err = io.Copy(outputWriter, io.LimitReader(body, maxFileSize))
 	if err != nil {
-		return nil, fmt.Errorf("failed to download data", err)
+		return nil, sourceErrorf("failed to download data: %w", err)
 	}
 
 	// check for unread bytes
 	var p [1]byte
-	n, err := resp.Body.Read(p[:])
-	if n != 0 && err != io.EOF {
-		return nil, fmt.Errorf("file is to big")
+	n, _ := resp.Body.Read(p[:])
+	if n != 0 {
+		return nil, sourceErrorf("file is to big")
 	}
 
-	// content, err := ioutil.ReadAll(resp.Body)
-	zipReader, err := zip.NewReader(bytes.NewReader(content.Bytes()), int64(content.Len()))
-	if err != nil {
-		return nil, err
-	}
-	return xlsx.ReadZipReader(zipReader)
-
+	return openXlsx(&content, i.cfg.GetString("file_member"))
 }
 
 func (i *SupragImport) Run() (*ImportSummary, error) {
@@ -119,54 +125,61 @@ func (i *SupragImport) Run() (*ImportSummary, error) {
 	}
 
 	i.summary.Start()
-
-	outputBufWriter := bufio.NewWriter(i.output)
+	defer i.summary.Stop()
 
 	xlFile, err := i.getXlsxFile()
 	if err != nil {
-		return i.summary, fmt.Errorf("failed to open xlsx", err)
+		return i.summary, fmt.Errorf("failed to open xlsx: %w", err)
 	}
 
 	if len(xlFile.Sheets) < 1 {
-		return i.summary, fmt.Errorf("no spreadsheetes in file")
+		return i.summary, parseErrorf("no spreadsheetes in file")
 	}
 
 	sheet := xlFile.Sheets[0]
 
 	lineNumber := i.cfg.GetInt("start_line") - 1
-SUPRAG_XLSX:
 	for _, row := range sheet.Rows[i.cfg.GetInt("start_line")-1:] {
 		lineNumber++
 		purchasePrice, err := row.Cells[7].Float()
 		if err != nil {
-			log.Println("failed to read line %d: could not parse %s", lineNumber, sheet.Rows[0].Cells[7])
+			log.Printf("failed to read line %d: could not parse %s\n", lineNumber, row.Cells[7])
+			i.summary.Reject(&Rejection{
+				Ref:    fmt.Sprintf("line %d", lineNumber),
+				Id:     row.Cells[0].String(),
+				Reason: "invalid purchase price",
+				Detail: row.Cells[7].String(),
+			})
 			continue
 		}
 		i.summary.Articles++
-		//handle ignored manufacturers
-		manufacturer := row.Cells[2].String()
-		for _, ignored_manufacturer := range i.cfg.GetStringSlice("ignored_manufacturers") {
-			if manufacturer == ignored_manufacturer {
-				i.summary.Ignored++
-				continue SUPRAG_XLSX
-			}
-		}
 		r := &Record{
 			Id:             row.Cells[0].String(),
 			IdPrefix:       i.cfg.GetString("id_prefix"),
 			Description:    row.Cells[9].String(),
-			PurchasePrice:  purchasePrice,
+			PurchasePrice:  NewMoney(purchasePrice),
 			PurchaseFactor: i.cfg.GetFloat64("purchase_factor"),
 			SellingFactor:  i.cfg.GetFloat64("selling_factor"),
-			SellingPrice:   purchasePrice * i.cfg.GetFloat64("selling_factor"),
+			SellingPrice:   NewMoney(purchasePrice).Mul(i.cfg.GetFloat64("selling_factor")),
 			Category:       "Suprag",
 			CategoryNumber: i.cfg.GetString("category_number"),
 		}
-		_, err = outputBufWriter.WriteString(r.FormatLine())
+		r.SetAttr(AttrManufacturer, row.Cells[2].String())
+		// optional columns with more attributes (e.g. ean: 10)
+		for name, index := range i.cfg.GetStringMap("attribute_columns") {
+			n := cast.ToInt(index)
+			if n >= 0 && n < len(row.Cells) {
+				r.SetAttr(name, row.Cells[n].String())
+			}
+		}
+		if reason, ignored := i.filter.Ignore(r); ignored {
+			i.summary.Ignore(reason)
+			continue
+		}
+		err = i.output.WriteRecord(r)
 		if err != nil {
 			return i.summary, err
 		}
 	}
-	outputBufWriter.Flush()
 	return i.summary, nil
 }
@@ -0,0 +1,127 @@
+package mip
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cast"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// Transform changes a text, e.g. the description of an article.
+type Transform func(s string) string
+
+// Transforms is a pipeline of transforms which are applied in order.
+type Transforms []Transform
+
+// Apply applies all transforms to s.
+func (t Transforms) Apply(s string) string {
+	for _, transform := range t {
+		s = transform(s)
+	}
+	return s
+}
+
+// NewTransforms returns the transforms of the configuration list. A transform
+// without argument is a string, a transform with argument a map:
+//
+//	trim                    remove leading and trailing whitespace
+//	collapse_space          replace runs of whitespace (incl. line breaks)
+//	                        with one space
+//	html_unescape           replace HTML entities (e.g. &amp;)
+//	title_case              "HP TONER" becomes "Hp Toner"
+//	replace: regex          replace the matches of regex with the value of
+//	with: replacement       with ($1 refers to the first group)
+//	prefix: text            add text at the beginning
+//	suffix: text            add text at the end
+//	truncate: n             shorten to at most n characters on a word
+//	                        boundary
+func NewTransforms(list []interface{}) (Transforms, error) {
+	var transforms Transforms
+	for n, entry := range list {
+		transform, err := newTransform(entry)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %w", n, err)
+		}
+		transforms = append(transforms, transform)
+	}
+	return transforms, nil
+}
+
+func newTransform(entry interface{}) (Transform, error) {
+	if name, ok := entry.(string); ok {
+		switch name {
+		case "trim":
+			return strings.TrimSpace, nil
+		case "collapse_space":
+			return func(s string) string { return strings.Join(strings.Fields(s), " ") }, nil
+		case "html_unescape":
+			return html.UnescapeString, nil
+		case "title_case":
+			return func(s string) string { return cases.Title(language.German).String(s) }, nil
+		}
+		return nil, configErrorf("unknown transform '%s'", name)
+	}
+
+	m := cast.ToStringMap(entry)
+	switch {
+	case m["replace"] != nil:
+		re, err := regexp.Compile(cast.ToString(m["replace"]))
+		if err != nil {
+			return nil, configErrorf("invalid regex: %w", err)
+		}
+		with := cast.ToString(m["with"])
+		return func(s string) string { return re.ReplaceAllString(s, with) }, nil
+	case m["prefix"] != nil:
+		prefix := cast.ToString(m["prefix"])
+		return func(s string) string { return prefix + s }, nil
+	case m["suffix"] != nil:
+		suffix := cast.ToString(m["suffix"])
+		return func(s string) string { return s + suffix }, nil
+	case m["truncate"] != nil:
+		max := cast.ToInt(m["truncate"])
+		if max <= 0 {
+			return nil, configErrorf("truncate needs a length greater than 0")
+		}
+		return func(s string) string { return truncate(s, max) }, nil
+	}
+	return nil, configErrorf("unknown transform %v", entry)
+}
+
+// truncate shortens s to at most max characters. It cuts at the last space
+// if there is one, otherwise within the word. Separators at the end are
+// removed.
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	cut := max
+	for i := max; i > 0; i-- {
+		if unicode.IsSpace(runes[i]) {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRight(string(runes[:cut]), " \t\r\n,;:-/&+")
+}
+
+// TransformWriter is a RecordWriter which transforms the descriptions of the
+// records before it writes them to the next writer.
+type TransformWriter struct {
+	transforms Transforms
+	w          RecordWriter
+}
+
+func NewTransformWriter(transforms Transforms, w RecordWriter) *TransformWriter {
+	return &TransformWriter{transforms: transforms, w: w}
+}
+
+func (t *TransformWriter) WriteRecord(r *Record) error {
+	r.Description = t.transforms.Apply(r.Description)
+	return t.w.WriteRecord(r)
+}
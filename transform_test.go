@@ -0,0 +1,25 @@
+package mip
+
+import "testing"
+
+func TestTruncate(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"Kabel", 10, "Kabel"},
+		{"Kabel", 5, "Kabel"},
+		{"HP Toner schwarz", 10, "HP Toner"},
+		{"HP Toner schwarz", 8, "HP Toner"},
+		{"Druckerpatrone", 7, "Drucker"},
+		{"Kabel, 2m lang", 8, "Kabel"},
+		{"Maus - kabellos", 7, "Maus"},
+		{"Ä Ö Ü ßßß", 6, "Ä Ö Ü"},
+		{"Größe", 3, "Grö"},
+	} {
+		if got := truncate(test.s, test.max); got != test.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", test.s, test.max, got, test.want)
+		}
+	}
+}
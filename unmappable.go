@@ -0,0 +1,151 @@
+package mip
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/unicode/norm"
+)
+
+// transliterations are the replacements of common characters which are
+// missing in the legacy encodings.
+var transliterations = map[rune]string{
+	'€':      "EUR",
+	'‘':      "'",
+	'’':      "'",
+	'‚':      "'",
+	'‹':      "<",
+	'›':      ">",
+	'“':      "\"",
+	'”':      "\"",
+	'„':      "\"",
+	'–':      "-",
+	'—':      "-",
+	'−':      "-",
+	'…':      "...",
+	'•':      "*",
+	'™':      "(TM)",
+	'‰':      "o/oo",
+	'Œ':      "OE",
+	'œ':      "oe",
+	'Ω':      "Ohm",
+	'\u2009': " ", // thin space
+	'\u202f': " ", // narrow no-break space
+	'\u200b': "",  // zero width space
+}
+
+// unmappableRunes replaces the characters which an encoding can not
+// represent.
+type unmappableRunes struct {
+	name     string
+	encoder  *encoding.Encoder
+	policy   string
+	mappable map[rune]bool
+	// records contains the keys of the records with unmappable characters
+	records []string
+}
+
+func newUnmappableRunes(name string, enc encoding.Encoding, policy string) *unmappableRunes {
+	return &unmappableRunes{
+		name:     name,
+		encoder:  enc.NewEncoder(),
+		policy:   policy,
+		mappable: map[rune]bool{},
+	}
+}
+
+func (u *unmappableRunes) isMappable(r rune) bool {
+	if r < utf8.RuneSelf {
+		return true
+	}
+	ok, seen := u.mappable[r]
+	if !seen {
+		_, err := u.encoder.String(string(r))
+		ok = err == nil
+		u.mappable[r] = ok
+	}
+	return ok
+}
+
+// transliterate returns a replacement for r which can be represented.
+func (u *unmappableRunes) transliterate(r rune) string {
+	if s, ok := transliterations[r]; ok && u.isMappableString(s) {
+		return s
+	}
+	// remove the accents, e.g. ő becomes o
+	var b strings.Builder
+	for _, c := range norm.NFD.String(string(r)) {
+		if unicode.Is(unicode.Mn, c) {
+			continue
+		}
+		if !u.isMappable(c) {
+			return "?"
+		}
+		b.WriteRune(c)
+	}
+	if b.Len() == 0 {
+		return "?"
+	}
+	return b.String()
+}
+
+func (u *unmappableRunes) isMappableString(s string) bool {
+	for _, r := range s {
+		if !u.isMappable(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// clean replaces the unmappable characters in the fields of the record r. It
+// is applied before the fields are quoted, so replacements like " are
+// escaped. The delimiter in replacements is replaced like in free text (see
+// CSVFormat.text).
+func (u *unmappableRunes) clean(r *Record, fields []string, f *CSVFormat) []string {
+	var cleaned []string
+	for n, field := range fields {
+		if u.isMappableString(field) {
+			continue
+		}
+		if cleaned == nil {
+			cleaned = append([]string(nil), fields...)
+			if u.policy == UnmappableFail {
+				u.records = append(u.records, r.Key())
+			}
+		}
+		var b strings.Builder
+		for _, c := range field {
+			switch {
+			case u.isMappable(c):
+				b.WriteRune(c)
+			case u.policy == UnmappableTransliterate:
+				b.WriteString(f.text(u.transliterate(c)))
+			default:
+				b.WriteByte('?')
+			}
+		}
+		cleaned[n] = b.String()
+	}
+	if cleaned == nil {
+		return fields
+	}
+	return cleaned
+}
+
+// err returns an error which lists the records with unmappable characters
+// if the policy is UnmappableFail.
+func (u *unmappableRunes) err() error {
+	if len(u.records) == 0 {
+		return nil
+	}
+	keys := u.records
+	more := ""
+	if len(keys) > 20 {
+		keys = keys[:20]
+		more = ", ..."
+	}
+	return outputErrorf("%d records contain characters which %s can not represent: %s%s", len(u.records), u.name, strings.Join(keys, ", "), more)
+}
@@ -0,0 +1,144 @@
+package mip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestUnmappableExport(t *testing.T) {
+	minimal := *DefaultCSVFormat
+	minimal.QuoteAll = false
+	star := *DefaultCSVFormat
+	star.Delimiter = '*'
+	keep := star
+	keep.ReplaceDelimiter = false
+
+	for _, test := range []struct {
+		name        string
+		policy      string
+		format      *CSVFormat
+		description string
+		want        string
+		line        string
+		err         bool
+	}{
+		{
+			name:        "quotes are escaped after the transliteration",
+			policy:      UnmappableTransliterate,
+			format:      DefaultCSVFormat,
+			description: "Kabel „lang“ “kurz” 5€",
+			want:        `Kabel "lang" "kurz" 5EUR`,
+			line:        `"A-1","Kabel ""lang"" ""kurz"" 5EUR"`,
+		},
+		{
+			name:        "minimal quoting",
+			policy:      UnmappableTransliterate,
+			format:      &minimal,
+			description: "Kabel “lang”",
+			want:        `Kabel "lang"`,
+			line:        `A-1,"Kabel ""lang"""`,
+		},
+		{
+			name:        "minimal quoting without quotes",
+			policy:      UnmappableTransliterate,
+			format:      &minimal,
+			description: "Kabel – 2m…",
+			want:        "Kabel - 2m...",
+			line:        "A-1,Kabel - 2m...",
+		},
+		{
+			name:        "replaced delimiter",
+			policy:      UnmappableTransliterate,
+			format:      &star,
+			description: "Maus • kabellos",
+			want:        "Maus   kabellos",
+			line:        `"A-1"*"Maus   kabellos"`,
+		},
+		{
+			name:        "kept delimiter",
+			policy:      UnmappableTransliterate,
+			format:      &keep,
+			description: "Maus • kabellos",
+			want:        "Maus * kabellos",
+			line:        `"A-1"*"Maus * kabellos"`,
+		},
+		{
+			name:        "accents",
+			policy:      UnmappableTransliterate,
+			format:      DefaultCSVFormat,
+			description: "Gerőfi Ĉapo",
+			want:        "Gerofi Capo",
+		},
+		{
+			name:        "mappable",
+			policy:      UnmappableTransliterate,
+			format:      DefaultCSVFormat,
+			description: "Größe \"XL\"",
+			want:        "Größe \"XL\"",
+		},
+		{
+			name:        "replace",
+			policy:      UnmappableReplace,
+			format:      DefaultCSVFormat,
+			description: "Kabel “lang” 5€",
+			want:        "Kabel ?lang? 5?",
+		},
+		{
+			name:        "html",
+			policy:      UnmappableHTML,
+			format:      DefaultCSVFormat,
+			description: "5€",
+			want:        "5&#8364;",
+		},
+		{
+			name:        "fail",
+			policy:      UnmappableFail,
+			format:      DefaultCSVFormat,
+			description: "5€",
+			want:        "5?",
+			err:         true,
+		},
+	} {
+		var buf bytes.Buffer
+		export, err := NewExport(&buf, "iso-8859-1", test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		export.SetFormat(test.format)
+		columns, err := ExportColumns([]string{"id", "description"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		export.SetColumns(columns)
+		if err := export.WriteRecord(&Record{Id: "1", IdPrefix: "A-", Description: test.description}); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		err = export.Close()
+		if test.err != (err != nil) {
+			t.Errorf("%s: Close returned %v", test.name, err)
+		}
+
+		content, err := charmap.ISO8859_1.NewDecoder().String(buf.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+		if test.line != "" && lines[1] != test.line {
+			t.Errorf("%s: line %s, want %s", test.name, lines[1], test.line)
+		}
+		r := csv.NewReader(strings.NewReader(content))
+		r.Comma = test.format.Delimiter
+		rows, err := r.ReadAll()
+		if err != nil {
+			t.Errorf("%s: invalid CSV %q: %s", test.name, content, err)
+			continue
+		}
+		if got := rows[1][1]; got != test.want {
+			t.Errorf("%s: description %q, want %q", test.name, got, test.want)
+		}
+	}
+}
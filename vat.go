@@ -0,0 +1,151 @@
+package mip
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// VATRate is a rate of the value added tax and its code in Messerli. The
+// code is empty until it is configured.
+type VATRate struct {
+	Name string
+	// Rate in percent, e.g. 8.1
+	Rate float64
+	Code string
+}
+
+// DefaultVATRates are the Swiss VAT rates valid since 2024-01-01. The special
+// rate applies to accommodation services. They have no codes, as the codes
+// depend on the setup of Messerli.
+var DefaultVATRates = map[string]*VATRate{
+	"standard": {Name: "standard", Rate: 8.1},
+	"reduced":  {Name: "reduced", Rate: 2.6},
+	"special":  {Name: "special", Rate: 3.8},
+	"exempt":   {Name: "exempt", Rate: 0},
+}
+
+// NewVATRates returns DefaultVATRates with the rates and codes configured in
+// cfg replaced or added. cfg may be nil.
+//
+//	rates   map of rate names to rate (in percent) and code in Messerli,
+//	        e.g. standard: {rate: 8.1, code: "<code>"}
+func NewVATRates(cfg *viper.Viper) (map[string]*VATRate, error) {
+	rates := make(map[string]*VATRate, len(DefaultVATRates))
+	for name, rate := range DefaultVATRates {
+		r := *rate
+		rates[name] = &r
+	}
+	if cfg == nil {
+		return rates, nil
+	}
+	for name, entry := range cfg.GetStringMap("rates") {
+		m := cast.ToStringMap(entry)
+		rate, ok := rates[name]
+		if !ok {
+			rate = &VATRate{Name: name}
+			rates[name] = rate
+		}
+		if value, set := m["rate"]; set {
+			var err error
+			rate.Rate, err = cast.ToFloat64E(value)
+			if err != nil || rate.Rate < 0 {
+				return nil, configErrorf("invalid rate for %s: %v", name, value)
+			}
+		}
+		if code, set := m["code"]; set {
+			rate.Code = cast.ToString(code)
+		}
+	}
+	return rates, nil
+}
+
+type vatRule struct {
+	match func(category string) bool
+	rate  *VATRate
+}
+
+// VATWriter is a RecordWriter which sets the VAT code of the records and
+// converts gross prices to net prices before it writes the records to the
+// next writer. Messerli expects net prices. The settings are read from cfg:
+//
+//	prices_include_vat   the prices of the supplier are gross prices
+//	                     (default false)
+//	rate                 name of the rate of the articles (default standard)
+//	rules                list of rules which select the rate by supplier
+//	                     category, the first matching rule is applied. a
+//	                     rule matches with exact, prefix or regex (see
+//	                     CategoryMapper) and sets rate
+type VATWriter struct {
+	gross bool
+	def   *VATRate
+	rules []*vatRule
+	w     RecordWriter
+}
+
+func NewVATWriter(cfg *viper.Viper, rates map[string]*VATRate, w RecordWriter) (*VATWriter, error) {
+	lookup := func(name string) (*VATRate, error) {
+		rate, ok := rates[name]
+		if !ok {
+			names := make([]string, 0, len(rates))
+			for name := range rates {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, configErrorf("unknown vat rate '%s' (known: %s)", name, strings.Join(names, ", "))
+		}
+		// a guessed code would be booked wrong in Messerli
+		if rate.Code == "" {
+			return nil, configErrorf("vat rate %s has no code, set vat.rates.%s.code", name, name)
+		}
+		return rate, nil
+	}
+	v := &VATWriter{
+		gross: cfg.GetBool("prices_include_vat"),
+		w:     w,
+	}
+	name := "standard"
+	if cfg.IsSet("rate") {
+		name = cfg.GetString("rate")
+	}
+	var err error
+	if v.def, err = lookup(name); err != nil {
+		return nil, err
+	}
+	for n, entry := range cast.ToSlice(cfg.Get("rules")) {
+		m := cast.ToStringMapString(entry)
+		rule := &vatRule{}
+		if rule.match, _, err = newCategoryMatch(m); err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", n, err)
+		}
+		if rule.rate, err = lookup(m["rate"]); err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", n, err)
+		}
+		v.rules = append(v.rules, rule)
+	}
+	return v, nil
+}
+
+// Rate returns the VAT rate of r.
+func (v *VATWriter) Rate(r *Record) *VATRate {
+	category := r.Attr(AttrSupplierCategory)
+	for _, rule := range v.rules {
+		if rule.match(category) {
+			return rule.rate
+		}
+	}
+	return v.def
+}
+
+func (v *VATWriter) WriteRecord(r *Record) error {
+	rate := v.Rate(r)
+	if v.gross {
+		net := func(m Money) Money { return m.Div(1 + rate.Rate/100) }
+		r.convertPrices(net, net)
+	}
+	r.SetAttr(AttrVATCode, rate.Code)
+	return v.w.WriteRecord(r)
+}
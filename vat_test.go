@@ -0,0 +1,125 @@
+package mip
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// vatCodes returns the default rates with the codes A, B and C.
+func vatCodes(t *testing.T) map[string]*VATRate {
+	cfg := viper.New()
+	cfg.Set("rates", map[string]interface{}{
+		"standard": map[string]interface{}{"code": "A"},
+		"reduced":  map[string]interface{}{"code": "B"},
+		"special":  map[string]interface{}{"code": "C"},
+	})
+	rates, err := NewVATRates(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rates
+}
+
+func TestVATWriter(t *testing.T) {
+	rates := vatCodes(t)
+	cfg := viper.New()
+	cfg.Set("prices_include_vat", true)
+	cfg.Set("rules", []interface{}{
+		map[string]interface{}{"prefix": "Bücher", "rate": "reduced"},
+		map[string]interface{}{"exact": "Hotel", "rate": "special"},
+	})
+	out := &RecordBuffer{}
+	w, err := NewVATWriter(cfg, rates, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		category string
+		gross    Money
+		net      Money
+		code     string
+	}{
+		{"Kabel", 1081000, 1000000, "A"},
+		{"Bücher/Romane", 1026000, 1000000, "B"},
+		{"Hotel", 1038000, 1000000, "C"},
+	} {
+		r := &Record{PurchasePrice: test.gross, SellingPrice: test.gross, PriceTiers: []PriceTier{{10, test.gross, test.gross}}}
+		r.SetAttr(AttrSupplierCategory, test.category)
+		if err := w.WriteRecord(r); err != nil {
+			t.Fatal(err)
+		}
+		if r.PurchasePrice != test.net || r.SellingPrice != test.net || r.PriceTiers[0].PurchasePrice != test.net {
+			t.Errorf("%s: net prices %s, %s and %s, want %s", test.category, r.PurchasePrice, r.SellingPrice, r.PriceTiers[0].PurchasePrice, test.net)
+		}
+		if got := r.Attr(AttrVATCode); got != test.code {
+			t.Errorf("%s: vat code %s, want %s", test.category, got, test.code)
+		}
+	}
+	if len(out.Records) != 3 {
+		t.Errorf("%d records written, want 3", len(out.Records))
+	}
+}
+
+func TestVATWriterWithoutCode(t *testing.T) {
+	rates, err := NewVATRates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the default rates have no codes
+	if _, err := NewVATWriter(viper.New(), rates, &RecordBuffer{}); err == nil {
+		t.Error("default rate without code: no error")
+	}
+	rates = vatCodes(t)
+	for _, settings := range []map[string]interface{}{
+		{"rate": "exempt"},
+		{"rules": []interface{}{map[string]interface{}{"exact": "Export", "rate": "exempt"}}},
+		{"rate": "unknown"},
+	} {
+		cfg := viper.New()
+		for key, value := range settings {
+			cfg.Set(key, value)
+		}
+		if _, err := NewVATWriter(cfg, rates, &RecordBuffer{}); err == nil {
+			t.Errorf("NewVATWriter(%v) returned no error", settings)
+		} else if KindOf(err) != KindConfig {
+			t.Errorf("NewVATWriter(%v) returned %v, want a config error", settings, err)
+		}
+	}
+}
+
+func TestNewVATRates(t *testing.T) {
+	cfg := viper.New()
+	cfg.Set("rates", map[string]interface{}{
+		"standard": map[string]interface{}{"rate": 7.7, "code": "A"},
+		"zero":     map[string]interface{}{"rate": 0, "code": "9"},
+	})
+	rates, err := NewVATRates(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := rates["standard"]; r.Rate != 7.7 || r.Code != "A" {
+		t.Errorf("standard = %+v", r)
+	}
+	if r := rates["zero"]; r == nil || r.Code != "9" {
+		t.Errorf("zero = %+v", r)
+	}
+	if r := rates["reduced"]; r.Rate != 2.6 || r.Code != "" {
+		t.Errorf("reduced = %+v", r)
+	}
+	// the defaults are not changed
+	if r := DefaultVATRates["standard"]; r.Rate != 8.1 || r.Code != "" {
+		t.Errorf("default standard rate changed to %+v", r)
+	}
+
+	for _, rates := range []map[string]interface{}{
+		{"standard": map[string]interface{}{"rate": -1}},
+		{"standard": map[string]interface{}{"rate": "high"}},
+	} {
+		cfg := viper.New()
+		cfg.Set("rates", rates)
+		if _, err := NewVATRates(cfg); err == nil {
+			t.Errorf("NewVATRates(%v) returned no error", rates)
+		}
+	}
+}